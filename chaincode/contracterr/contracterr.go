@@ -0,0 +1,102 @@
+// Package contracterr defines the errors returned by the vaccination slot contract.
+//
+// Every error returned by a transaction is an *Error, its message is the JSON
+//
+//	{"code":"NOT_FOUND","message":"slot 42 doesn't exist"}
+//
+// so clients can branch on the code instead of parsing the message.
+package contracterr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Code is a stable machine-readable error code.
+//
+// A Code is an error itself, so errors.Is(err, contracterr.NotFound) reports whether err has the code.
+type Code string
+
+const (
+	// NotFound is returned if a slot, offer or other record doesn't exist.
+	NotFound Code = "NOT_FOUND"
+	// Unauthorized is returned if the client lacks the required role or doesn't own the record.
+	Unauthorized Code = "UNAUTHORIZED"
+	// Conflict is returned if the transaction clashes with the ledger state, e.g. an occupied date.
+	Conflict Code = "CONFLICT"
+	// Expired is returned if the slot or date is in the past.
+	Expired Code = "EXPIRED"
+	// RuleViolation is returned if the transaction breaks a rule of the contract, e.g. a missed deadline.
+	RuleViolation Code = "RULE_VIOLATION"
+	// InvalidArgument is returned if an argument can't be parsed or is out of range.
+	InvalidArgument Code = "INVALID_ARGUMENT"
+	// Internal is returned for failures of the ledger or of the contract itself.
+	Internal Code = "INTERNAL"
+)
+
+func (c Code) Error() string {
+	return string(c)
+}
+
+// Error is an error with a code, Error() returns it serialized as JSON.
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// New returns an *Error with code and the formatted message.
+func New(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	errBytes, err := json.Marshal(e)
+	if err != nil {
+		return string(e.Code) + ": " + e.Message
+	}
+	return string(errBytes)
+}
+
+// Is reports whether target is the code of e.
+func (e *Error) Is(target error) bool {
+	code, ok := target.(Code)
+	return ok && code == e.Code
+}
+
+// CodeOf returns the code of err, Internal if err isn't an *Error.
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Internal
+}
+
+// MessageOf returns the message of err without the code.
+func MessageOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return err.Error()
+}
+
+// Annotate prefixes the message of err, keeping its code.
+func Annotate(err error, format string, args ...interface{}) error {
+	return New(CodeOf(err), "%s: %s", fmt.Sprintf(format, args...), MessageOf(err))
+}
+
+// Normalize turns the error pointed by err into an *Error, errors without code become Internal.
+// Transactions defer it on their named error result.
+func Normalize(err *error) {
+	if *err == nil {
+		return
+	}
+	var e *Error
+	if errors.As(*err, &e) {
+		*err = e
+		return
+	}
+	*err = &Error{Code: Internal, Message: (*err).Error()}
+}
@@ -0,0 +1,51 @@
+package contracterr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	err := New(NotFound, "slot %s doesn't exist", "slot1")
+	assert.JSONEq(t, `{"code":"NOT_FOUND","message":"slot slot1 doesn't exist"}`, err.Error())
+	assert.ErrorIs(t, err, NotFound)
+	assert.False(t, errors.Is(err, Conflict))
+
+	decoded := &Error{}
+	assert.Nil(t, json.Unmarshal([]byte(err.Error()), decoded))
+	assert.Equal(t, NotFound, decoded.Code)
+}
+
+func TestCodeOf(t *testing.T) {
+	assert.Equal(t, Conflict, CodeOf(New(Conflict, "slot occupied")))
+	assert.Equal(t, Conflict, CodeOf(fmt.Errorf("wrapped: %w", New(Conflict, "slot occupied"))))
+	assert.Equal(t, Internal, CodeOf(errors.New("failed to get state")))
+}
+
+func TestAnnotate(t *testing.T) {
+	err := Annotate(New(RuleViolation, "date is too late"), "entry %d", 3)
+	assert.ErrorIs(t, err, RuleViolation)
+	assert.Equal(t, "entry 3: date is too late", MessageOf(err))
+
+	err = Annotate(errors.New("failed to get state"), "entry %d", 3)
+	assert.ErrorIs(t, err, Internal)
+}
+
+func TestNormalize(t *testing.T) {
+	var err error
+	Normalize(&err)
+	assert.Nil(t, err)
+
+	err = errors.New("failed to get state")
+	Normalize(&err)
+	assert.JSONEq(t, `{"code":"INTERNAL","message":"failed to get state"}`, err.Error())
+
+	err = fmt.Errorf("wrapped: %w", New(Expired, "slot has expired"))
+	Normalize(&err)
+	assert.ErrorIs(t, err, Expired)
+	assert.Equal(t, "slot has expired", MessageOf(err))
+}
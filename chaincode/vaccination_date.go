@@ -3,6 +3,8 @@ package chaincode
 import (
 	"strings"
 	"time"
+
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
 )
 
 // VaccinationDate is a simplified date format to identify specific occasions
@@ -14,6 +16,11 @@ func (vd *VaccinationDate) MarshalJSON() ([]byte, error) {
 	return []byte("\"" + str + "\""), nil
 }
 
+// String formats the date in 2006-01-02 format
+func (vd VaccinationDate) String() string {
+	return time.Time(vd).Format("2006-01-02")
+}
+
 // UnmarshalJSON unmarshals date from 2006-01-02 format
 func (vd *VaccinationDate) UnmarshalJSON(b []byte) (err error) {
 	s := strings.Trim(string(b), `"`)
@@ -21,3 +28,13 @@ func (vd *VaccinationDate) UnmarshalJSON(b []byte) (err error) {
 	*vd = VaccinationDate(nt)
 	return
 }
+
+// parseDate parses a date argument in 2006-01-02 format.
+func parseDate(s string) (VaccinationDate, error) {
+	vd := VaccinationDate{}
+	err := vd.UnmarshalJSON([]byte(s))
+	if err != nil {
+		return vd, contracterr.New(contracterr.InvalidArgument, "invalid date %s, the format must be 2006-01-02", s)
+	}
+	return vd, nil
+}
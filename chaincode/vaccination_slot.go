@@ -8,15 +8,31 @@ type VaccinationSlotData struct {
 	Type VaccinationType `json:"type"`
 
 	// When the vaccine should be administered.
-	// Never changes.
+	// Only changes when the medical station reschedules the slot, see RescheduleHistory.
 	Date VaccinationDate `json:"date"`
 
+	// Vaccination site of the slot, empty for the default site.
+	// Only changes when BulkReschedule moves the slot to another site.
+	Site string `json:"site,omitempty"`
+
 	// Previously administered vaccine of the same type.
 	// If present it may forbid the transfer of the token.
 	// May change when the token is transferred.
 	Previous string `json:"previous,omitempty"`
 
-	Burned bool `json:"burned,omitempty"`
+	// Series of the patient the slot is a dose of and its dose number, see Series.
+	// Empty for slots issued before series existed.
+	SeriesId string `json:"seriesId,omitempty"`
+	Dose     int    `json:"dose,omitempty"`
+
+	// Lifecycle state of the slot, see transitions for the allowed changes.
+	Status SlotStatus `json:"status"`
+
+	// Every status change of the slot in chronological order.
+	StatusHistory []StatusChange `json:"statusHistory,omitempty"`
+
+	// Every date change of the slot in chronological order.
+	RescheduleHistory []Reschedule `json:"rescheduleHistory,omitempty"`
 }
 
 // VaccinationSlot contains ERC712 related data (this is the NFT)
@@ -25,6 +41,7 @@ type VaccinationSlot struct {
 	TokenId  string `json:"tokenId"`
 	Owner    string `json:"owner"`
 	Approved string `json:"approved"`
+	Version  int    `json:"schemaVersion"`
 }
 
 type Approval struct {
@@ -37,6 +54,48 @@ type ApprovalForAll struct {
 	Owner    string `json:"owner"`
 	Operator string `json:"operator"`
 	Approved bool   `json:"approved"`
+	Version  int    `json:"schemaVersion,omitempty"`
+}
+
+// SlotCancelled is emitted when a patient gives a slot back to the medical station.
+// AssignedTo is the patient on the waitlist the slot is given to, empty if it stays in the pool.
+type SlotCancelled struct {
+	From       string          `json:"from"`
+	TokenId    string          `json:"tokenId"`
+	Type       VaccinationType `json:"type"`
+	Date       VaccinationDate `json:"date"`
+	AssignedTo string          `json:"assignedTo,omitempty"`
+}
+
+// SlotRescheduled is emitted when the medical station moves a slot to another date.
+type SlotRescheduled struct {
+	TokenId string          `json:"tokenId"`
+	Owner   string          `json:"owner"`
+	OldDate VaccinationDate `json:"oldDate"`
+	NewDate VaccinationDate `json:"newDate"`
+	Reason  string          `json:"reason"`
+}
+
+// NextDoseIssued is emitted when BurnToken issues the next dose of a series automatically.
+type NextDoseIssued struct {
+	Owner    string          `json:"owner"`
+	Previous string          `json:"previous"`
+	SeriesId string          `json:"seriesId"`
+	Dose     int             `json:"dose"`
+	TokenId  string          `json:"tokenId"`
+	Type     VaccinationType `json:"type"`
+	Date     VaccinationDate `json:"date"`
+	Site     string          `json:"site,omitempty"`
+}
+
+// NextDoseUnscheduled is emitted instead of NextDoseIssued if there was no free date in the dose interval,
+// the next dose has to be issued with IssueSlot.
+type NextDoseUnscheduled struct {
+	Owner    string          `json:"owner"`
+	Previous string          `json:"previous"`
+	SeriesId string          `json:"seriesId"`
+	Dose     int             `json:"dose"`
+	Type     VaccinationType `json:"type"`
 }
 
 type Transfer struct {
@@ -60,4 +119,5 @@ type TradeOffer struct {
 	SenderItem    string `json:"senderItem"`
 	Recipient     string `json:"recipient"`
 	RecipientItem string `json:"recipientItem"`
+	Version       int    `json:"schemaVersion"`
 }
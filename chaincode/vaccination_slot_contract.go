@@ -3,61 +3,76 @@ package chaincode
 import (
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
 )
 
 // VaccinationContract is a smart contract for managing vaccination slots.
 // Implements ERC-721.
+//
+// TradePolicy decides which offers can be accepted, ConfigTradePolicy is used if it's nil.
 type VaccinationContract struct {
 	contractapi.Contract
 	IdGenerator TokenIdGeneratorInterface
+	TradePolicy TradePolicy
 }
 
 func (c *VaccinationContract) sender(ctx contractapi.TransactionContextInterface) (string, error) {
-	sender64, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return "", errors.New("failed to get client identity")
-	}
-	senderBytes, err := base64.StdEncoding.DecodeString(sender64)
-	if err != nil {
-		return "", fmt.Errorf("failed to deocde base64 string sender64: %v", err)
-	}
-	return string(senderBytes), nil
+	return getSender(ctx)
 }
 
-func (c *VaccinationContract) ClientAccountId(ctx contractapi.TransactionContextInterface) (string, error) {
+// ClientAccountId returns the pseudonym of the invoking client.
+func (c *VaccinationContract) ClientAccountId(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "ClientAccountId")
+	if err != nil {
+		return "", err
+	}
 	return c.sender(ctx)
 }
 
-// GetSlots queries vaccination slots belonging to owner
-func (c *VaccinationContract) getSlots(ctx contractapi.TransactionContextInterface, owner string) ([]*VaccinationSlot, error) {
+// getSlots queries vaccination slots belonging to owner
+func getSlots(ctx contractapi.TransactionContextInterface, owner string) ([]*VaccinationSlot, error) {
 	owner64 := base64.StdEncoding.EncodeToString([]byte(owner))
 	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(balancePrefix, []string{owner64})
 	if err != nil {
-		panic("Error creating asset chaincode: " + err.Error())
+		return nil, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", balancePrefix, err)
 	}
+	defer iterator.Close()
 
 	slots := make([]*VaccinationSlot, 0)
 	for iterator.HasNext() {
 		slot, err := iterator.Next()
 		if err != nil {
-			return nil, errors.New("failure while iterating")
+			return nil, fmt.Errorf("failure while iterating: %w", err)
 		}
 		vs, err := readVaccinationSlot(ctx, string(slot.Value))
 		if err != nil {
-			return nil, errors.New("error reading vaccinationSlot")
+			return nil, err
 		}
 		slots = append(slots, vs)
 	}
 	return slots, nil
 }
 
-func (c *VaccinationContract) GetSlots(ctx contractapi.TransactionContextInterface, owner string) (string, error) {
-	slots, err := c.getSlots(ctx, owner)
+// GetSlots queries vaccination slots belonging to the owner pseudonym.
+// Only the owner, the medical station and verifiers with a record consent of the owner can read them.
+func (c *VaccinationContract) GetSlots(ctx contractapi.TransactionContextInterface, owner string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetSlots")
+	if err != nil {
+		return "", err
+	}
+	err = authorizeRead(ctx, owner, ConsentRecord)
+	if err != nil {
+		return "", err
+	}
+
+	slots, err := getSlots(ctx, owner)
 	if err != nil {
 		return "", err
 	}
@@ -68,101 +83,291 @@ func (c *VaccinationContract) GetSlots(ctx contractapi.TransactionContextInterfa
 	return string(slotsBytes), nil
 }
 
+// GetSlotsByStatus queries the slots of the owner pseudonym in the given status.
+// It's authorized like GetSlots.
+//
+// Only issued, administered and cancelled slots are kept in the balance of their owner,
+// the other statuses are rejected.
+func (c *VaccinationContract) GetSlotsByStatus(ctx contractapi.TransactionContextInterface, owner string, status string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetSlotsByStatus")
+	if err != nil {
+		return "", err
+	}
+
+	if !SlotStatus(status).IsValid() {
+		return "", contracterr.New(contracterr.InvalidArgument, "unknown slot status: %s", status)
+	}
+	if !SlotStatus(status).isIndexed() {
+		return "", contracterr.New(contracterr.InvalidArgument, "%s slots are removed from the balance of their owner, see GetSlotHistory", status)
+	}
+	err = authorizeRead(ctx, owner, ConsentRecord)
+	if err != nil {
+		return "", err
+	}
+
+	slots, err := getSlots(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+
+	filtered := make([]*VaccinationSlot, 0)
+	for _, slot := range slots {
+		if slot.Status == SlotStatus(status) {
+			filtered = append(filtered, slot)
+		}
+	}
+
+	slotsBytes, err := json.Marshal(filtered)
+	if err != nil {
+		return "", err
+	}
+	return string(slotsBytes), nil
+}
+
+// dateOccupied reports whether owner already has a slot occupying date.
+func dateOccupied(ctx contractapi.TransactionContextInterface, owner string, date VaccinationDate) (bool, error) {
+	slots, err := getSlots(ctx, owner)
+	if err != nil {
+		return false, err
+	}
+
+	for _, slot := range slots {
+		if slot.occupies() && slot.Date.String() == date.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // IssueSlot can be used by doctors to issue vaccination slots to patients.
 //
 // Vaccine must be one of the values defined VaccinationType enum.
 //
 // Date format must be 2006-01-02.
-func (c *VaccinationContract) IssueSlot(ctx contractapi.TransactionContextInterface, vaccine, date, patient, previous string) (string, error) {
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+//
+// The slot is owned by the pseudonym of the patient, see PseudonymOf.
+//
+// A client request id can be passed in the requestId transient key,
+// a retry with the same id returns the token id of the first call, see RequestRecord.
+func (c *VaccinationContract) IssueSlot(ctx contractapi.TransactionContextInterface, vaccine, date, patient, previous string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "IssueSlot")
+	if err != nil {
+		return "", err
+	}
+
+	owner, err := pseudonym(ctx, patient)
 	if err != nil {
-		return "", fmt.Errorf("failed to get client MSPID: %v", err)
+		return "", err
 	}
 
-	if clientMSPID != "MedicalStationMSP" {
-		return "", fmt.Errorf("client is not authorized to create slot")
+	request, replayed, err := replayRequest(ctx, "IssueSlot", vaccine, date, owner, previous)
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return request.Result, nil
 	}
 
-	slots, err := c.getSlots(ctx, patient)
+	tokenId, err := c.issueSlot(ctx, vaccine, date, "", owner, previous)
 	if err != nil {
 		return "", err
 	}
 
-	for _, slot := range slots {
-		dateBytes, err := json.Marshal(&slot.Date)
-		if err != nil {
-			return "", err
-		}
-		if string(dateBytes) == "\""+date+"\"" {
-			return "", errors.New("slot occupied")
-		}
+	err = saveRequest(ctx, request, tokenId)
+	if err != nil {
+		return "", err
 	}
+	return tokenId, nil
+}
 
-	tokenUuid := c.IdGenerator.Next()
+// IssueSlotAt works like IssueSlot for a slot at the given vaccination site.
+func (c *VaccinationContract) IssueSlotAt(ctx contractapi.TransactionContextInterface, vaccine, date, site, patient, previous string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
 
-	exists, err := vaccinationSlotExists(ctx, tokenUuid)
+	err = c.authorize(ctx, "IssueSlotAt")
 	if err != nil {
 		return "", err
 	}
-	if exists {
-		return "", errors.New("token already exists (better luck next time)")
+
+	owner, err := pseudonym(ctx, patient)
+	if err != nil {
+		return "", err
+	}
+
+	request, replayed, err := replayRequest(ctx, "IssueSlotAt", vaccine, date, site, owner, previous)
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return request.Result, nil
+	}
+
+	tokenId, err := c.issueSlot(ctx, vaccine, date, site, owner, previous)
+	if err != nil {
+		return "", err
+	}
+
+	err = saveRequest(ctx, request, tokenId)
+	if err != nil {
+		return "", err
+	}
+	return tokenId, nil
+}
+
+// issueSlot validates the slot parameters and mints the slot to owner.
+// Authorization is the responsibility of the caller.
+func (c *VaccinationContract) issueSlot(ctx contractapi.TransactionContextInterface, vaccine, date, site, owner, previous string) (string, error) {
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	vd := &VaccinationDate{}
-	err = json.Unmarshal([]byte("\""+date+"\""), vd)
+	vs, err := c.checkIssue(ctx, cfg, vaccine, date, site, owner, previous, newPendingMoves())
 	if err != nil {
 		return "", err
 	}
 
-	vt := new(VaccinationType)
-	err = json.Unmarshal([]byte("\""+vaccine+"\""), vt)
+	err = c.mintSlot(ctx, cfg, vs)
 	if err != nil {
 		return "", err
 	}
 
-	vs := &VaccinationSlot{
+	err = c.emitTransfer(ctx, "", owner, vs.TokenId)
+	if err != nil {
+		return "", err
+	}
+
+	return vs.TokenId, nil
+}
+
+// checkIssue validates the slot parameters and returns the slot to mint with a new token id.
+// Pending holds the slots issued earlier in the transaction.
+func (c *VaccinationContract) checkIssue(ctx contractapi.TransactionContextInterface, cfg ContractConfig, vaccine, date, site, owner, previous string, pending *pendingMoves) (*VaccinationSlot, error) {
+	vt, err := parseVaccine(vaccine)
+	if err != nil {
+		return nil, err
+	}
+
+	vd, err := parseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	err = checkInterval(ctx, cfg, previous, vd)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCompatibility(ctx, cfg, previous, vt)
+	if err != nil {
+		return nil, err
+	}
+
+	occupied := pending.dates[owner+"|"+vd.String()]
+	if !occupied {
+		occupied, err = dateOccupied(ctx, owner, vd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if occupied {
+		return nil, contracterr.New(contracterr.Conflict, "slot occupied on %s", vd)
+	}
+
+	free, err := hasCapacity(ctx, cfg, site, vd, pending.taken[site+"|"+vd.String()])
+	if err != nil {
+		return nil, err
+	}
+	if !free {
+		return nil, contracterr.New(contracterr.Conflict, "site %s is full on %s", site, date)
+	}
+
+	tokenUuid := c.IdGenerator.Next()
+
+	exists, err := vaccinationSlotExists(ctx, tokenUuid)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, contracterr.New(contracterr.Conflict, "token %s already exists", tokenUuid)
+	}
+
+	seriesId, dose, err := nextDose(ctx, owner, previous, tokenUuid, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VaccinationSlot{
 		VaccinationSlotData: VaccinationSlotData{
-			Type:     *vt,
-			Date:     *vd,
+			Type:     vt,
+			Date:     vd,
+			Site:     site,
 			Previous: previous,
+			SeriesId: seriesId,
+			Dose:     dose,
+			Status:   StatusIssued,
 		},
 		TokenId: tokenUuid,
-		Owner:   patient,
+		Owner:   owner,
+	}, nil
+}
+
+// mintSlot stores a slot validated by checkIssue with its indexes and the series it starts.
+func (c *VaccinationContract) mintSlot(ctx contractapi.TransactionContextInterface, cfg ContractConfig, vs *VaccinationSlot) error {
+	err := startSeries(ctx, cfg, vs)
+	if err != nil {
+		return err
 	}
 
 	err = vs.put(ctx)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	err = vs.putBalance(ctx)
-
-	err = c.emitTransfer(ctx, "", patient, tokenUuid)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return tokenUuid, nil
+	return vs.putSiteDate(ctx)
 }
 
+// MakeOffer offers mySlotUuid of the sender in exchange for recipientSlotUuid of the recipient pseudonym.
 func (c *VaccinationContract) MakeOffer(ctx contractapi.TransactionContextInterface, mySlotUuid, recipient, recipientSlotUuid string) (offerUuid string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "MakeOffer")
+	if err != nil {
+		return "", err
+	}
+
+	request, replayed, err := replayRequest(ctx, "MakeOffer", mySlotUuid, recipient, recipientSlotUuid)
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return request.Result, nil
+	}
+
 	mySlot, err := readVaccinationSlot(ctx, mySlotUuid)
 	if err != nil {
-		return "", fmt.Errorf("slot: %s doesn't exist", mySlotUuid)
+		return "", err
 	}
 	recipientSlot, err := readVaccinationSlot(ctx, recipientSlotUuid)
 	if err != nil {
-		return "", fmt.Errorf("slot: %s doesn't exist", recipientSlotUuid)
+		return "", err
 	}
 	sender, err := getSender(ctx)
 	if err != nil {
 		return "", err
 	}
 	if sender != mySlot.Owner {
-		return "", fmt.Errorf("%s doesn't own %s", sender, mySlotUuid)
+		return "", contracterr.New(contracterr.Unauthorized, "%s doesn't own %s", sender, mySlotUuid)
 	}
 	if recipient != recipientSlot.Owner {
-		return "", fmt.Errorf("%s doesn't own %s", recipient, recipientSlotUuid)
+		return "", contracterr.New(contracterr.InvalidArgument, "%s doesn't own %s", recipient, recipientSlotUuid)
 	}
 
 	offerUuid = c.IdGenerator.Next()
@@ -180,78 +385,34 @@ func (c *VaccinationContract) MakeOffer(ctx contractapi.TransactionContextInterf
 		return "", err
 	}
 
+	err = saveRequest(ctx, request, offerUuid)
+	if err != nil {
+		return "", err
+	}
+
 	return
 }
 
-func (c *VaccinationContract) AcceptOffer(ctx contractapi.TransactionContextInterface, offerUuid string) error {
-	offer, err := getOffer(ctx, offerUuid)
-	if err != nil {
-		return err
-	}
-	recipient, err := getSender(ctx)
-	if err != nil {
-		return fmt.Errorf("error getting sender in offer")
-	}
-	if recipient != offer.Recipient {
-		return fmt.Errorf("%s is not the recipient of the offer: %s", recipient, offerUuid)
-	}
-	senderSlot, err := readVaccinationSlot(ctx, offer.SenderItem)
+// AcceptOffer swaps the slots of the offer if it passes every rule of the TradePolicy, see CheckOffer.
+func (c *VaccinationContract) AcceptOffer(ctx contractapi.TransactionContextInterface, offerUuid string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "AcceptOffer")
 	if err != nil {
 		return err
 	}
-	recipientSlot, err := readVaccinationSlot(ctx, offer.RecipientItem)
+
+	trade, rules, err := c.loadTrade(ctx, offerUuid)
 	if err != nil {
 		return err
 	}
-	if senderSlot.Owner != offer.Sender {
-		return fmt.Errorf("sender: %s doesn't own the slot", senderSlot.Owner)
-	}
-	if recipientSlot.Owner != recipient {
-		return fmt.Errorf("recipient: %s doesn't own the slot", recipient)
-	}
-
-	if senderSlot.Burned {
-		return fmt.Errorf("sender slot is burned")
-	}
-
-	if recipientSlot.Burned {
-		return fmt.Errorf("recipient slot is burned")
-	}
-
-	if time.Time(senderSlot.Date).Before(time.Now()) {
-		return fmt.Errorf("sender slot has expired")
-	}
-	if time.Time(recipientSlot.Date).Before(time.Now()) {
-		return fmt.Errorf("recipient slot has expired")
-	}
-
-	if len(senderSlot.Previous) > 0 {
-		prev, err := readVaccinationSlot(ctx, senderSlot.Previous)
-		if err != nil {
-			return fmt.Errorf("sender previous slot present but can't be read: %v", err)
-		}
-		deadlineDuration, ok := deadlines[prev.Type]
-		if !ok {
-			return fmt.Errorf("can't find deadline for: %s", string(prev.Type))
-		}
-		if time.Time(prev.Date).Add(deadlineDuration).Before(time.Time(recipientSlot.Date)) {
-			return fmt.Errorf("recipient slot's date it too late")
-		}
-	}
-
-	if len(recipientSlot.Previous) > 0 {
-		prev, err := readVaccinationSlot(ctx, recipientSlot.Previous)
+	for _, rule := range rules {
+		err = rule.Check(ctx, trade)
 		if err != nil {
-			return fmt.Errorf("sender previous slot present but can't be read: %v", err)
-		}
-		deadlineDuration, ok := deadlines[prev.Type]
-		if !ok {
-			return fmt.Errorf("can't find deadline for: %s", string(prev.Type))
-		}
-		if time.Time(prev.Date).Add(deadlineDuration).Before(time.Time(senderSlot.Date)) {
-			return fmt.Errorf("sender slot's date it too late")
+			return err
 		}
 	}
+	offer, senderSlot, recipientSlot := trade.Offer, trade.SenderSlot, trade.RecipientSlot
 
 	err = senderSlot.delBalance(ctx)
 	if err != nil {
@@ -267,6 +428,8 @@ func (c *VaccinationContract) AcceptOffer(ctx contractapi.TransactionContextInte
 	senderSlot.Owner, recipientSlot.Owner = recipientSlot.Owner, senderSlot.Owner
 	senderSlot.Type, recipientSlot.Type = recipientSlot.Type, senderSlot.Type
 	senderSlot.Previous, recipientSlot.Previous = recipientSlot.Previous, senderSlot.Previous
+	senderSlot.SeriesId, recipientSlot.SeriesId = recipientSlot.SeriesId, senderSlot.SeriesId
+	senderSlot.Dose, recipientSlot.Dose = recipientSlot.Dose, senderSlot.Dose
 
 	err = senderSlot.put(ctx)
 	if err != nil {
@@ -291,6 +454,16 @@ func (c *VaccinationContract) AcceptOffer(ctx contractapi.TransactionContextInte
 		return err
 	}
 
+	for _, rule := range rules {
+		if rule.Record == nil {
+			continue
+		}
+		err = rule.Record(ctx, trade)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = c.emitTransfer(ctx, offer.Sender, offer.Recipient, offer.SenderItem)
 	if err != nil {
 		return err
@@ -303,7 +476,14 @@ func (c *VaccinationContract) AcceptOffer(ctx contractapi.TransactionContextInte
 	return nil
 }
 
-func (c *VaccinationContract) ListOffers(ctx contractapi.TransactionContextInterface) (string, error) {
+func (c *VaccinationContract) ListOffers(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "ListOffers")
+	if err != nil {
+		return "", err
+	}
+
 	sender, err := getSender(ctx)
 	if err != nil {
 		return "", err
@@ -319,7 +499,14 @@ func (c *VaccinationContract) ListOffers(ctx contractapi.TransactionContextInter
 	return string(offersBytes), nil
 }
 
-func (c *VaccinationContract) DeleteOffer(ctx contractapi.TransactionContextInterface, offerUuid string) error {
+func (c *VaccinationContract) DeleteOffer(ctx contractapi.TransactionContextInterface, offerUuid string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "DeleteOffer")
+	if err != nil {
+		return err
+	}
+
 	sender, err := getSender(ctx)
 	if err != nil {
 		return err
@@ -333,24 +520,84 @@ func (c *VaccinationContract) DeleteOffer(ctx contractapi.TransactionContextInte
 			return err
 		}
 	} else {
-		return fmt.Errorf("%s isn't the sender or recipient of the offer", sender)
+		return contracterr.New(contracterr.Unauthorized, "%s isn't the sender or recipient of the offer", sender)
 	}
 	return nil
 }
 
-func (c *VaccinationContract) BurnToken(ctx contractapi.TransactionContextInterface, slotUuid string) error {
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+// BurnToken marks the slot administered.
+//
+// If the vaccine type has autoNextDose in the configuration and the slot is a dose of a series with doses left,
+// the next dose is issued to the owner on the first date of the dose interval with a free place at the site, see NextDoseIssued.
+func (c *VaccinationContract) BurnToken(ctx contractapi.TransactionContextInterface, slotUuid string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "BurnToken")
+	if err != nil {
+		return err
+	}
+
+	slot, err := c.changeStatus(ctx, slotUuid, StatusAdministered)
+	if err != nil {
+		return err
+	}
+	return c.issueNextDose(ctx, slot)
+}
+
+// RevokeSlot withdraws a live slot, e.g. because it was issued by mistake, and deletes its offers.
+func (c *VaccinationContract) RevokeSlot(ctx contractapi.TransactionContextInterface, slotUuid string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "RevokeSlot")
 	if err != nil {
-		return fmt.Errorf("failed to get client MSPID: %v", err)
+		return err
 	}
 
-	if clientMSPID != "MedicalStationMSP" {
-		return fmt.Errorf("client is not authorized to create slot")
+	_, err = c.changeStatus(ctx, slotUuid, StatusRevoked)
+	return err
+}
+
+// MarkNoShow marks a live slot whose owner didn't show up and deletes its offers.
+func (c *VaccinationContract) MarkNoShow(ctx contractapi.TransactionContextInterface, slotUuid string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "MarkNoShow")
+	if err != nil {
+		return err
 	}
+
+	_, err = c.changeStatus(ctx, slotUuid, StatusNoShow)
+	return err
+}
+
+// changeStatus moves the slot to status and deletes the offers referencing it.
+// The slot is removed from the balance of its owner unless status is indexed, see isIndexed.
+func (c *VaccinationContract) changeStatus(ctx contractapi.TransactionContextInterface, slotUuid string, status SlotStatus) (*VaccinationSlot, error) {
 	slot, err := readVaccinationSlot(ctx, slotUuid)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = slot.transition(ctx, status)
+	if err != nil {
+		return nil, err
+	}
+
+	if !status.isIndexed() {
+		err = slot.delBalance(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The slot isn't live anymore, so its offers can't be accepted.
+	err = delSlotOffers(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = slot.put(ctx)
+	if err != nil {
+		return nil, err
 	}
-	slot.Burned = true
-	return slot.put(ctx)
+	return slot, nil
 }
@@ -0,0 +1,270 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// MaxBatchSize is the maximum number of entries accepted by IssueSlots.
+const MaxBatchSize = 500
+
+// transientBatch is the transient key read by IssueSlots.
+const transientBatch = "batch"
+
+// IssueRequest is an entry of the batch of IssueSlots, see IssueSlotAt for the fields.
+type IssueRequest struct {
+	Vaccine  string `json:"vaccine"`
+	Date     string `json:"date"`
+	Site     string `json:"site,omitempty"`
+	Patient  string `json:"patient"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// IssueResult is the outcome of the entry at Index of the batch.
+// Code and Error are set if the entry hasn't been issued.
+type IssueResult struct {
+	Index   int              `json:"index"`
+	TokenId string           `json:"tokenId,omitempty"`
+	Code    contracterr.Code `json:"code,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// BatchIssueResult is returned by IssueSlots.
+type BatchIssueResult struct {
+	Issued  int           `json:"issued"`
+	Failed  int           `json:"failed"`
+	Results []IssueResult `json:"results"`
+}
+
+// SlotsIssued is emitted by IssueSlots instead of a Transfer event per slot,
+// because only the last event of a transaction is delivered.
+type SlotsIssued struct {
+	Slots []Transfer `json:"slots"`
+}
+
+// IssueSlots issues a batch of slots given as a JSON array of IssueRequest in the "batch" key of the transient map,
+// so the patient identities never appear in the transaction arguments, see IssueSlotTransient.
+//
+// Every entry is validated like IssueSlotAt, and a patient can't have two entries on the same date.
+// Without partial the batch is all-or-nothing, the first invalid entry fails the transaction.
+// With partial the valid entries are issued and the others are reported with the error.
+// The slots are owned by the pseudonyms of the patients, the identities are only written to the medical station's private collection.
+// A client request id can be passed in the requestId transient key, a retry returns the result of the first call.
+// Emits a SlotsIssued event.
+func (c *VaccinationContract) IssueSlots(ctx contractapi.TransactionContextInterface, partial bool) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "IssueSlots")
+	if err != nil {
+		return "", err
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transient map: %w", err)
+	}
+	batchJSON, ok := transient[transientBatch]
+	if !ok || len(batchJSON) == 0 {
+		return "", contracterr.New(contracterr.InvalidArgument, "%s must be present in the transient map", transientBatch)
+	}
+
+	requests := make([]IssueRequest, 0)
+	err = json.Unmarshal(batchJSON, &requests)
+	if err != nil {
+		return "", contracterr.New(contracterr.InvalidArgument, "failed to unmarshal batch: %v", err)
+	}
+	if len(requests) == 0 {
+		return "", contracterr.New(contracterr.InvalidArgument, "batch is empty")
+	}
+	if len(requests) > MaxBatchSize {
+		return "", contracterr.New(contracterr.InvalidArgument, "batch has %d entries, the maximum is %d", len(requests), MaxBatchSize)
+	}
+
+	// The request is hashed with the pseudonyms, so the stored hash doesn't disclose the patients.
+	owners := make([]IssueRequest, len(requests))
+	for i, req := range requests {
+		owners[i] = req
+		if len(req.Patient) == 0 {
+			continue
+		}
+		owners[i].Patient, err = pseudonym(ctx, req.Patient)
+		if err != nil {
+			return "", err
+		}
+	}
+	ownersBytes, err := json.Marshal(owners)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	request, replayed, err := replayRequest(ctx, "IssueSlots", string(ownersBytes), strconv.FormatBool(partial))
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return request.Result, nil
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	result := &BatchIssueResult{Results: make([]IssueResult, 0, len(requests))}
+	slots := make([]*VaccinationSlot, 0, len(requests))
+	pending := newPendingMoves()
+	seen := make(map[string]int)
+
+	for i, req := range owners {
+		vs, err := c.checkBatchEntry(ctx, cfg, req, seen, i, pending)
+		if err != nil {
+			if !partial {
+				return "", contracterr.Annotate(err, "entry %d", i)
+			}
+			result.Failed++
+			result.Results = append(result.Results, IssueResult{Index: i, Code: contracterr.CodeOf(err), Error: contracterr.MessageOf(err)})
+			continue
+		}
+
+		pending.add(vs)
+		slots = append(slots, vs)
+		result.Issued++
+		result.Results = append(result.Results, IssueResult{Index: i, TokenId: vs.TokenId})
+	}
+
+	event := &SlotsIssued{Slots: make([]Transfer, 0, len(slots))}
+	for _, vs := range slots {
+		err = c.mintSlot(ctx, cfg, vs)
+		if err != nil {
+			return "", err
+		}
+		event.Slots = append(event.Slots, Transfer{To: vs.Owner, TokenId: vs.TokenId})
+	}
+
+	recorded := make(map[string]bool)
+	for _, res := range result.Results {
+		owner := owners[res.Index].Patient
+		if len(res.TokenId) == 0 || recorded[owner] {
+			continue
+		}
+		recorded[owner] = true
+		record := &PatientRecord{
+			Pseudonym: owner,
+			Patient:   requests[res.Index].Patient,
+		}
+		err = record.put(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(slots) > 0 {
+		err = emitSlotsIssued(ctx, event)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	err = saveRequest(ctx, request, string(resultBytes))
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+// checkBatchEntry validates the entry at index i of the batch, its patient is already a pseudonym.
+// Seen maps the patients and dates of the earlier entries to their index.
+func (c *VaccinationContract) checkBatchEntry(ctx contractapi.TransactionContextInterface, cfg ContractConfig, req IssueRequest, seen map[string]int, i int, pending *pendingMoves) (*VaccinationSlot, error) {
+	if len(req.Patient) == 0 {
+		return nil, contracterr.New(contracterr.InvalidArgument, "patient must be set")
+	}
+
+	key := req.Patient + "|" + req.Date
+	if j, ok := seen[key]; ok {
+		return nil, contracterr.New(contracterr.Conflict, "duplicate of entry %d", j)
+	}
+	seen[key] = i
+
+	return c.checkIssue(ctx, cfg, req.Vaccine, req.Date, req.Site, req.Patient, req.Previous, pending)
+}
+
+func emitSlotsIssued(ctx contractapi.TransactionContextInterface, event *SlotsIssued) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SlotsIssued: %w", err)
+	}
+
+	err = ctx.GetStub().SetEvent("SlotsIssued", eventBytes)
+	if err != nil {
+		return fmt.Errorf("failed to SetEvent SlotsIssued: %w", err)
+	}
+	return nil
+}
+
+// ChunkIssueRequests splits requests into values of the batch transient key of IssueSlots with at most size entries.
+// It's meant for clients, a size above MaxBatchSize is lowered to MaxBatchSize.
+//
+// Entries of the same patient and date end up in the same chunk, so IssueSlots can reject the duplicates.
+func ChunkIssueRequests(requests []IssueRequest, size int) ([]string, error) {
+	if size < 1 {
+		return nil, contracterr.New(contracterr.InvalidArgument, "size must be positive")
+	}
+	if size > MaxBatchSize {
+		size = MaxBatchSize
+	}
+
+	// Group the duplicates, keeping the order of the first occurrences.
+	groups := make([][]IssueRequest, 0)
+	index := make(map[string]int)
+	for _, req := range requests {
+		key := req.Patient + "|" + req.Date
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], req)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []IssueRequest{req})
+	}
+
+	chunks := make([]string, 0)
+	chunk := make([]IssueRequest, 0, size)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		chunkBytes, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, string(chunkBytes))
+		chunk = make([]IssueRequest, 0, size)
+		return nil
+	}
+
+	for _, group := range groups {
+		if len(group) > size {
+			return nil, contracterr.New(contracterr.InvalidArgument, "patient %s has %d entries on %s, more than the chunk size", group[0].Patient, len(group), group[0].Date)
+		}
+		if len(chunk)+len(group) > size {
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+		}
+		chunk = append(chunk, group...)
+	}
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
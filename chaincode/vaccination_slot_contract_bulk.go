@@ -0,0 +1,301 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// RescheduleStrategy selects the new place of the slots moved by BulkReschedule.
+type RescheduleStrategy string
+
+const (
+	// StrategyOtherSite keeps the date and moves the slots to the target site.
+	StrategyOtherSite RescheduleStrategy = "other-site"
+	// StrategyNextFreeDay moves every slot to the first day after the closure
+	// with a free place at the target site, or at the closed site if there is no target.
+	StrategyNextFreeDay RescheduleStrategy = "next-free-day"
+)
+
+// maxRescheduleDays limits the days searched by StrategyNextFreeDay.
+const maxRescheduleDays = 60
+
+// AllSites selects the slots of every site in BulkReschedule, the empty site is the default site.
+const AllSites = "*"
+
+// BulkRescheduleItem is the outcome of a slot examined by BulkReschedule.
+// Code and Error are set if the slot couldn't be moved.
+type BulkRescheduleItem struct {
+	TokenId string           `json:"tokenId"`
+	Owner   string           `json:"owner"`
+	OldSite string           `json:"oldSite"`
+	OldDate string           `json:"oldDate"`
+	NewSite string           `json:"newSite,omitempty"`
+	NewDate string           `json:"newDate,omitempty"`
+	Code    contracterr.Code `json:"code,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// BulkRescheduleResult is returned by BulkReschedule.
+//
+// Bookmark is the last examined key, it has to be passed to the next call until Done is true.
+type BulkRescheduleResult struct {
+	Examined int                  `json:"examined"`
+	Moved    int                  `json:"moved"`
+	Failed   int                  `json:"failed"`
+	Items    []BulkRescheduleItem `json:"items"`
+	Bookmark string               `json:"bookmark"`
+	Done     bool                 `json:"done"`
+}
+
+// SlotsRescheduled is emitted by BulkReschedule if any slot has been moved.
+type SlotsRescheduled struct {
+	Site     string               `json:"site"`
+	From     string               `json:"from"`
+	To       string               `json:"to"`
+	Strategy RescheduleStrategy   `json:"strategy"`
+	Slots    []BulkRescheduleItem `json:"slots"`
+}
+
+// bulkRequest holds the validated parameters of BulkReschedule.
+type bulkRequest struct {
+	site     string
+	from     VaccinationDate
+	to       VaccinationDate
+	strategy RescheduleStrategy
+	target   string
+}
+
+// targetOf returns the site the slot is moved to, its own site if there is no target.
+func (req bulkRequest) targetOf(slot *VaccinationSlot) string {
+	if len(req.target) == 0 {
+		return slot.Site
+	}
+	return req.target
+}
+
+// BulkReschedule moves the live and pooled slots of a closed site between fromDate and toDate, both inclusive.
+// Site AllSites closes every site, the empty site is the default site.
+// At most pageSize index entries between fromDate and toDate are examined by a call.
+//
+// With StrategyOtherSite the slots keep their date at the target site, it requires a single site.
+// With StrategyNextFreeDay they move to the first suitable day after toDate at the target site,
+// or at their own site if there is no target.
+// Every move is validated like RescheduleSlot, slots that can't be moved are reported with the error.
+// Emits a SlotsRescheduled event.
+//
+// Date format must be 2006-01-02.
+func (c *VaccinationContract) BulkReschedule(ctx contractapi.TransactionContextInterface, site, fromDate, toDate, strategy, target string, pageSize int, bookmark string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "BulkReschedule")
+	if err != nil {
+		return "", err
+	}
+
+	req, err := parseBulkRequest(site, fromDate, toDate, strategy, target)
+	if err != nil {
+		return "", err
+	}
+	if pageSize < 1 {
+		return "", contracterr.New(contracterr.InvalidArgument, "pageSize must be positive")
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	result := &BulkRescheduleResult{Items: make([]BulkRescheduleItem, 0), Bookmark: bookmark}
+	result.Done, err = c.bulkReschedulePage(ctx, cfg, req, now, pageSize, result)
+	if err != nil {
+		return "", err
+	}
+
+	if result.Moved > 0 {
+		err = emitSlotsRescheduled(ctx, req, result.Items)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+func parseBulkRequest(site, fromDate, toDate, strategy, target string) (bulkRequest, error) {
+	req := bulkRequest{
+		site:     site,
+		strategy: RescheduleStrategy(strategy),
+		target:   target,
+	}
+	var err error
+	req.from, err = parseDate(fromDate)
+	if err != nil {
+		return req, err
+	}
+	req.to, err = parseDate(toDate)
+	if err != nil {
+		return req, err
+	}
+	if time.Time(req.to).Before(time.Time(req.from)) {
+		return req, contracterr.New(contracterr.InvalidArgument, "fromDate must not be after toDate")
+	}
+	if target == AllSites {
+		return req, contracterr.New(contracterr.InvalidArgument, "target must be a single site")
+	}
+
+	switch req.strategy {
+	case StrategyOtherSite:
+		if site == AllSites {
+			return req, contracterr.New(contracterr.InvalidArgument, "other-site strategy requires a single site")
+		}
+		if len(target) == 0 || target == site {
+			return req, contracterr.New(contracterr.InvalidArgument, "other-site strategy requires another target site")
+		}
+	case StrategyNextFreeDay:
+	default:
+		return req, contracterr.New(contracterr.InvalidArgument, "unknown strategy: %s", strategy)
+	}
+	return req, nil
+}
+
+// bulkReschedulePage moves the slots of the page after result.Bookmark.
+// It reports whether every index entry between the dates has been examined.
+//
+// Paginated queries are only allowed in read-only transactions,
+// so the iteration starts from the first entry of the site and skips the keys up to the bookmark.
+// The entries outside the dates are skipped by their key before counting them.
+func (c *VaccinationContract) bulkReschedulePage(ctx contractapi.TransactionContextInterface, cfg ContractConfig, req bulkRequest, now time.Time, pageSize int, result *BulkRescheduleResult) (bool, error) {
+	attributes := []string{req.site}
+	reason := fmt.Sprintf("site %s closed from %s to %s", req.site, req.from, req.to)
+	if req.site == AllSites {
+		attributes = []string{}
+		reason = fmt.Sprintf("every site closed from %s to %s", req.from, req.to)
+	}
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(siteDatePrefix, attributes)
+	if err != nil {
+		return false, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", siteDatePrefix, err)
+	}
+	defer iterator.Close()
+
+	pending := newPendingMoves()
+	from, to := req.from.String(), req.to.String()
+
+	after := result.Bookmark
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return false, fmt.Errorf("failure while iterating: %w", err)
+		}
+		if len(after) > 0 && kv.Key <= after {
+			continue
+		}
+		_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil {
+			return false, fmt.Errorf("failed to SplitCompositeKey %s: %w", kv.Key, err)
+		}
+		if len(keyAttributes) != 3 || keyAttributes[1] < from || keyAttributes[1] > to {
+			continue
+		}
+		if result.Examined == pageSize {
+			return false, nil
+		}
+		result.Examined++
+		result.Bookmark = kv.Key
+
+		slot, err := readVaccinationSlot(ctx, string(kv.Value))
+		if err != nil {
+			return false, err
+		}
+		if slot.Site != keyAttributes[0] || slot.Date.String() != keyAttributes[1] {
+			continue
+		}
+		if !slot.IsLive() && slot.Status != StatusCancelled {
+			continue
+		}
+
+		item := BulkRescheduleItem{
+			TokenId: slot.TokenId,
+			Owner:   slot.Owner,
+			OldSite: slot.Site,
+			OldDate: slot.Date.String(),
+		}
+
+		date, err := c.bulkTarget(ctx, cfg, req, slot, now, pending)
+		if err != nil {
+			item.Code = contracterr.CodeOf(err)
+			item.Error = contracterr.MessageOf(err)
+			result.Failed++
+			result.Items = append(result.Items, item)
+			continue
+		}
+
+		_, err = c.moveSlot(ctx, slot, req.targetOf(slot), date, reason, now, pending)
+		if err != nil {
+			return false, err
+		}
+		item.NewSite = slot.Site
+		item.NewDate = slot.Date.String()
+		result.Moved++
+		result.Items = append(result.Items, item)
+	}
+	return true, nil
+}
+
+// bulkTarget returns the new date of the slot according to the strategy.
+func (c *VaccinationContract) bulkTarget(ctx contractapi.TransactionContextInterface, cfg ContractConfig, req bulkRequest, slot *VaccinationSlot, now time.Time, pending *pendingMoves) (VaccinationDate, error) {
+	target := req.targetOf(slot)
+	if req.strategy == StrategyOtherSite {
+		err := c.checkMove(ctx, cfg, slot, target, slot.Date, now, pending)
+		return slot.Date, err
+	}
+
+	var err error
+	for day := 1; day <= maxRescheduleDays; day++ {
+		date := VaccinationDate(time.Time(req.to).AddDate(0, 0, day))
+		err = c.checkMove(ctx, cfg, slot, target, date, now, pending)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return VaccinationDate{}, contracterr.Annotate(err, "no free day within %d days", maxRescheduleDays)
+}
+
+func emitSlotsRescheduled(ctx contractapi.TransactionContextInterface, req bulkRequest, items []BulkRescheduleItem) error {
+	moved := make([]BulkRescheduleItem, 0)
+	for _, item := range items {
+		if len(item.Error) == 0 {
+			moved = append(moved, item)
+		}
+	}
+
+	event := &SlotsRescheduled{
+		Site:     req.site,
+		From:     req.from.String(),
+		To:       req.to.String(),
+		Strategy: req.strategy,
+		Slots:    moved,
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SlotsRescheduled: %w", err)
+	}
+
+	err = ctx.GetStub().SetEvent("SlotsRescheduled", eventBytes)
+	if err != nil {
+		return fmt.Errorf("failed to SetEvent SlotsRescheduled: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// CancelSlot gives a live slot of the sender back to the medical station.
+//
+// The slot is moved to the pool identity of the configuration and given to the first eligible patient
+// on the waitlist of its vaccine type and site, see AssignFromWaitlist.
+// If no patient is eligible, it stays in the pool, where the medical station can reassign it with ReassignSlot.
+// The offers of the sender referencing the slot are deleted, a series started by the slot is closed.
+// Emits a SlotCancelled event with the patient the slot is assigned to.
+func (c *VaccinationContract) CancelSlot(ctx contractapi.TransactionContextInterface, slotUuid string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "CancelSlot")
+	if err != nil {
+		return err
+	}
+
+	sender, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+	slot, err := readVaccinationSlot(ctx, slotUuid)
+	if err != nil {
+		return err
+	}
+	if slot.Owner != sender {
+		return contracterr.New(contracterr.Unauthorized, "%s doesn't own %s", sender, slotUuid)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if time.Time(slot.Date).Before(now) {
+		return contracterr.New(contracterr.Expired, "slot %s has expired", slotUuid)
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = slot.transition(ctx, StatusCancelled)
+	if err != nil {
+		return err
+	}
+	err = slot.delBalance(ctx)
+	if err != nil {
+		return err
+	}
+	err = delSlotOffers(ctx, slot)
+	if err != nil {
+		return err
+	}
+	err = closeSeries(ctx, slot)
+	if err != nil {
+		return err
+	}
+
+	// The previous dose and the series belong to the patient, not to the slot.
+	slot.Owner = cfg.PoolIdentity
+	slot.Approved = ""
+	slot.Previous = ""
+	slot.SeriesId = ""
+	slot.Dose = 0
+
+	err = slot.put(ctx)
+	if err != nil {
+		return err
+	}
+	err = slot.putBalance(ctx)
+	if err != nil {
+		return err
+	}
+
+	assigned, err := c.assignFromWaitlist(ctx, cfg, slot, sender)
+	if err != nil {
+		return err
+	}
+	return c.emitSlotCancelled(ctx, sender, assigned, slot)
+}
+
+// ReassignSlot issues a cancelled slot of the pool to patient.
+// Slots in the past can't be reassigned.
+func (c *VaccinationContract) ReassignSlot(ctx contractapi.TransactionContextInterface, slotUuid, patient string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "ReassignSlot")
+	if err != nil {
+		return err
+	}
+
+	owner, err := pseudonym(ctx, patient)
+	if err != nil {
+		return err
+	}
+
+	slot, err := readVaccinationSlot(ctx, slotUuid)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if time.Time(slot.Date).Before(now) {
+		return contracterr.New(contracterr.Expired, "slot %s has expired", slotUuid)
+	}
+
+	return c.reassignSlot(ctx, slot, owner, "")
+}
+
+// reassignSlot moves a cancelled slot from the pool to owner with the previous dose of owner.
+func (c *VaccinationContract) reassignSlot(ctx contractapi.TransactionContextInterface, slot *VaccinationSlot, owner, previous string) error {
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if slot.Status != StatusCancelled || slot.Owner != cfg.PoolIdentity {
+		return contracterr.New(contracterr.RuleViolation, "slot %s is not in the pool", slot.TokenId)
+	}
+
+	occupied, err := dateOccupied(ctx, owner, slot.Date)
+	if err != nil {
+		return err
+	}
+	if occupied {
+		return contracterr.New(contracterr.Conflict, "slot occupied on %s", slot.Date)
+	}
+	err = checkInterval(ctx, cfg, previous, slot.Date)
+	if err != nil {
+		return err
+	}
+	err = checkCompatibility(ctx, cfg, previous, slot.Type)
+	if err != nil {
+		return err
+	}
+	seriesId, dose, err := nextDose(ctx, owner, previous, slot.TokenId, newPendingMoves())
+	if err != nil {
+		return err
+	}
+
+	err = slot.transition(ctx, StatusIssued)
+	if err != nil {
+		return err
+	}
+	err = slot.delBalance(ctx)
+	if err != nil {
+		return err
+	}
+
+	slot.Owner = owner
+	slot.Previous = previous
+	slot.SeriesId = seriesId
+	slot.Dose = dose
+
+	err = startSeries(ctx, cfg, slot)
+	if err != nil {
+		return err
+	}
+	err = slot.put(ctx)
+	if err != nil {
+		return err
+	}
+	err = slot.putBalance(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.emitTransfer(ctx, cfg.PoolIdentity, owner, slot.TokenId)
+}
+
+func (c *VaccinationContract) emitSlotCancelled(ctx contractapi.TransactionContextInterface, from, assigned string, slot *VaccinationSlot) error {
+	event := &SlotCancelled{
+		From:       from,
+		TokenId:    slot.TokenId,
+		Type:       slot.Type,
+		Date:       slot.Date,
+		AssignedTo: assigned,
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SlotCancelled: %w", err)
+	}
+
+	err = ctx.GetStub().SetEvent("SlotCancelled", eventBytes)
+	if err != nil {
+		return fmt.Errorf("failed to SetEvent SlotCancelled: %w", err)
+	}
+	return nil
+}
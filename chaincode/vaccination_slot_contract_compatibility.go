@@ -0,0 +1,59 @@
+package chaincode
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// CompatibilityRule allows a dose of Next after a dose of Previous.
+// The days between the two doses are bounded by the dose interval of Previous, see VaccineConfig.
+type CompatibilityRule struct {
+	Previous VaccinationType `json:"previous"`
+	Next     VaccinationType `json:"next"`
+}
+
+// validateCompatibility checks the compatibility matrix of the configuration.
+func validateCompatibility(rules []CompatibilityRule) error {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if !rule.Previous.IsValid() || !rule.Next.IsValid() {
+			return contracterr.New(contracterr.InvalidArgument, "unknown vaccine type in compatibility %s -> %s", rule.Previous, rule.Next)
+		}
+		pair := string(rule.Previous) + "|" + string(rule.Next)
+		if seen[pair] {
+			return contracterr.New(contracterr.InvalidArgument, "duplicate compatibility %s -> %s", rule.Previous, rule.Next)
+		}
+		seen[pair] = true
+	}
+	return nil
+}
+
+// compatible reports whether a dose of next may follow a dose of previous.
+// Without a matrix in the configuration only the same type may follow.
+func (cfg ContractConfig) compatible(previous, next VaccinationType) bool {
+	if len(cfg.Compatibility) == 0 {
+		return previous == next
+	}
+	for _, rule := range cfg.Compatibility {
+		if rule.Previous == previous && rule.Next == next {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCompatibility validates a dose of next after the previous slot against the compatibility matrix.
+func checkCompatibility(ctx contractapi.TransactionContextInterface, cfg ContractConfig, previous string, next VaccinationType) error {
+	if len(previous) == 0 {
+		return nil
+	}
+	prev, err := readVaccinationSlot(ctx, previous)
+	if err != nil {
+		return contracterr.Annotate(err, "previous slot present but can't be read")
+	}
+
+	if !cfg.compatible(prev.Type, next) {
+		return contracterr.New(contracterr.RuleViolation, "%s can't follow %s", next, prev.Type)
+	}
+	return nil
+}
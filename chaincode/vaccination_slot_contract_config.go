@@ -0,0 +1,292 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+	"github.com/perryd01/vaccination-slot/internal/config"
+)
+
+const (
+	configPrefix   = "config"
+	configContract = "contract"
+)
+
+// Defaults used when the embedded network configuration is unavailable.
+const (
+	defaultDoctorMSP  = "MedicalStationMSP"
+	defaultPatientMSP = "PatientsMSP"
+)
+
+// defaultPoolIdentity owns the cancelled slots until they are reassigned.
+const defaultPoolIdentity = "pool"
+
+// ContractConfig is the network and contract configuration stored in the world state.
+//
+// Until InitLedger is called, DefaultConfig is in effect.
+type ContractConfig struct {
+	Network config.Network `json:"network"`
+	Policy  AccessPolicy   `json:"policy"`
+
+	// Owner of the cancelled slots of the medical station.
+	PoolIdentity string `json:"poolIdentity"`
+
+	// Settings of the vaccination sites by name, sites missing from the map have unlimited capacity.
+	Sites map[string]SiteConfig `json:"sites,omitempty"`
+
+	// Days a RequestRecord is kept before PurgeRequests deletes it.
+	RequestRetentionDays int `json:"requestRetentionDays"`
+
+	// Optional trade rules of ConfigTradePolicy.
+	Trade TradeConfig `json:"trade"`
+
+	// Minimum days between two doses of the vaccine types without minIntervalDays.
+	MinIntervalDays int `json:"minIntervalDays"`
+
+	// Dose intervals of the vaccine types, the built-in intervals apply to the missing types.
+	Vaccines map[VaccinationType]VaccineConfig `json:"vaccines,omitempty"`
+
+	// Allowed follow-up vaccine types, only the same type may follow if it's empty.
+	Compatibility []CompatibilityRule `json:"compatibility,omitempty"`
+
+	Version int `json:"schemaVersion"`
+}
+
+// DefaultConfig is built from the embedded network configuration.
+func DefaultConfig() ContractConfig {
+	network := config.Network{
+		DoctorMspid:  defaultDoctorMSP,
+		PatientMspid: defaultPatientMSP,
+	}
+	if n := config.NetworkConfig(); n != nil {
+		network = *n
+	}
+	cfg := ContractConfig{
+		Network: network,
+		Policy:  defaultAccessPolicy(network.DoctorMspid, network.PatientMspid),
+		Version: SchemaVersion,
+	}
+	cfg.applyDefaults()
+	return cfg
+}
+
+// applyDefaults fills the optional settings missing from the configuration.
+func (cfg *ContractConfig) applyDefaults() {
+	if len(cfg.Policy.Roles) == 0 {
+		cfg.Policy = defaultAccessPolicy(cfg.Network.DoctorMspid, cfg.Network.PatientMspid)
+	}
+	if len(cfg.PoolIdentity) == 0 {
+		cfg.PoolIdentity = defaultPoolIdentity
+	}
+	if cfg.RequestRetentionDays == 0 {
+		cfg.RequestRetentionDays = defaultRequestRetentionDays
+	}
+	if cfg.MinIntervalDays == 0 {
+		cfg.MinIntervalDays = defaultMinIntervalDays
+	}
+}
+
+// parseConfig unmarshals and validates configJSON.
+// The access policy is derived from the network MSPs if it's missing.
+func parseConfig(configJSON string) (ContractConfig, error) {
+	cfg := ContractConfig{}
+	err := json.Unmarshal([]byte(configJSON), &cfg)
+	if err != nil {
+		return cfg, contracterr.New(contracterr.InvalidArgument, "failed to unmarshal config: %v", err)
+	}
+
+	if len(cfg.Network.DoctorMspid) == 0 || len(cfg.Network.PatientMspid) == 0 {
+		return cfg, contracterr.New(contracterr.InvalidArgument, "doctor_mspid and patient_mspid must be set")
+	}
+	cfg.applyDefaults()
+
+	err = cfg.Policy.validate()
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.RequestRetentionDays < 0 {
+		return cfg, contracterr.New(contracterr.InvalidArgument, "requestRetentionDays must not be negative")
+	}
+	err = cfg.Trade.validate()
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.MinIntervalDays < 0 {
+		return cfg, contracterr.New(contracterr.InvalidArgument, "minIntervalDays must not be negative")
+	}
+	err = cfg.validateVaccines()
+	if err != nil {
+		return cfg, err
+	}
+	err = validateCompatibility(cfg.Compatibility)
+	if err != nil {
+		return cfg, err
+	}
+	for site, siteCfg := range cfg.Sites {
+		if siteCfg.Capacity < 0 {
+			return cfg, contracterr.New(contracterr.InvalidArgument, "capacity of site %s must not be negative", site)
+		}
+	}
+	return cfg, nil
+}
+
+func configKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(configPrefix, []string{configContract})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+func readConfig(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	key, err := configKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	configBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state %s: %w", key, err)
+	}
+	return configBytes, nil
+}
+
+// getConfig returns the stored configuration or DefaultConfig if there is none.
+func getConfig(ctx contractapi.TransactionContextInterface) (ContractConfig, error) {
+	configBytes, err := readConfig(ctx)
+	if err != nil {
+		return ContractConfig{}, err
+	}
+	if len(configBytes) == 0 {
+		return DefaultConfig(), nil
+	}
+
+	cfg := ContractConfig{}
+	err = decodeRecord(configPrefix, configBytes, &cfg)
+	if err != nil {
+		return ContractConfig{}, fmt.Errorf("failed to decode config: %w", err)
+	}
+	cfg.applyDefaults()
+	return cfg, nil
+}
+
+func (cfg ContractConfig) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := configKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg.Version = SchemaVersion
+	configBytes, err := json.Marshal(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(key, configBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+// InitLedger stores the initial configuration. It can only be called once.
+//
+// An empty configJSON stores DefaultConfig.
+// Config format: {"network":{"organizations":["MedicalStation","Patients"],"channel":"vaccinationchannel",
+// "doctor_mspid":"MedicalStationMSP","patient_mspid":"PatientsMSP"},"policy":{"roles":{...}},"sites":{"north":{"capacity":100}},
+// "trade":{"sameSite":true,"minNoticeHours":48},"compatibility":[{"previous":"alpha","next":"alpha","minDays":21,"maxDays":42}]}
+func (c *VaccinationContract) InitLedger(ctx contractapi.TransactionContextInterface, configJSON string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "InitLedger")
+	if err != nil {
+		return err
+	}
+
+	configBytes, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if len(configBytes) > 0 {
+		return contracterr.New(contracterr.Conflict, "ledger is already initialized")
+	}
+
+	cfg := DefaultConfig()
+	if len(configJSON) > 0 {
+		cfg, err = parseConfig(configJSON)
+		if err != nil {
+			return err
+		}
+	}
+	return cfg.put(ctx)
+}
+
+// GetConfig returns the configuration in effect.
+func (c *VaccinationContract) GetConfig(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetConfig")
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	configBytes, err := json.Marshal(&cfg)
+	if err != nil {
+		return "", err
+	}
+	return string(configBytes), nil
+}
+
+// UpdateConfig updates the configuration, see InitLedger for the format.
+//
+// Only the top-level fields present in configJSON are replaced, the omitted ones keep their stored values,
+// so e.g. {"sites":{"north":{"capacity":50}}} changes the sites and keeps the policy, the vaccines,
+// the compatibility matrix and the retention. A field set to null is reset to its default.
+func (c *VaccinationContract) UpdateConfig(ctx contractapi.TransactionContextInterface, configJSON string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "UpdateConfig")
+	if err != nil {
+		return err
+	}
+
+	update := make(map[string]json.RawMessage)
+	err = json.Unmarshal([]byte(configJSON), &update)
+	if err != nil {
+		return contracterr.New(contracterr.InvalidArgument, "failed to unmarshal config: %v", err)
+	}
+
+	stored, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+	storedBytes, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	fields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(storedBytes, &fields)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	for name, value := range update {
+		fields[name] = value
+	}
+	mergedBytes, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	cfg, err := parseConfig(string(mergedBytes))
+	if err != nil {
+		return err
+	}
+	return cfg.put(ctx)
+}
@@ -0,0 +1,214 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const consentPrefix = "consent"
+
+// Consent scopes.
+const (
+	// ConsentRecord lets a verifier read the vaccination record of the patient, see GetVaccinationRecord.
+	ConsentRecord = "record"
+	// ConsentVaccinated lets a verifier check whether the patient is vaccinated, see IsVaccinated.
+	ConsentVaccinated = "vaccinated"
+)
+
+// consentScopes are the scopes a patient can grant.
+var consentScopes = map[string]bool{
+	ConsentRecord:     true,
+	ConsentVaccinated: true,
+}
+
+// Consent is the permission of Verifier to read the data of Patient in Scope until Until.
+// Both are pseudonyms, consents are stored as consent.patient.verifier.scope.
+type Consent struct {
+	Patient   string    `json:"patient"`
+	Verifier  string    `json:"verifier"`
+	Scope     string    `json:"scope"`
+	GrantedAt time.Time `json:"grantedAt"`
+	Until     time.Time `json:"until"`
+	Version   int       `json:"schemaVersion"`
+}
+
+func consentKey(ctx contractapi.TransactionContextInterface, patient, verifier, scope string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(consentPrefix, []string{patient, verifier, scope})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+func (consent *Consent) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := consentKey(ctx, consent.Patient, consent.Verifier, consent.Scope)
+	if err != nil {
+		return err
+	}
+
+	consent.Version = SchemaVersion
+	consentBytes, err := json.Marshal(consent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal consent: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(key, consentBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+// getConsent returns the consent of patient to verifier in scope or nil if there is none.
+func getConsent(ctx contractapi.TransactionContextInterface, patient, verifier, scope string) (*Consent, error) {
+	key, err := consentKey(ctx, patient, verifier, scope)
+	if err != nil {
+		return nil, err
+	}
+	consentBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state %s: %w", key, err)
+	}
+	if len(consentBytes) == 0 {
+		return nil, nil
+	}
+
+	consent := &Consent{}
+	err = decodeRecord(consentPrefix, consentBytes, consent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return consent, nil
+}
+
+// hasConsent reports whether verifier has an unexpired consent of patient in scope at now.
+func hasConsent(ctx contractapi.TransactionContextInterface, patient, verifier, scope string, now time.Time) (bool, error) {
+	consent, err := getConsent(ctx, patient, verifier, scope)
+	if err != nil || consent == nil {
+		return false, err
+	}
+	return now.Before(consent.Until), nil
+}
+
+// GrantConsent lets the verifier pseudonym read the data of the sender in scope for the given number of days.
+// A new grant replaces the earlier one of the verifier and scope.
+func (c *VaccinationContract) GrantConsent(ctx contractapi.TransactionContextInterface, verifier, scope string, days int) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GrantConsent")
+	if err != nil {
+		return err
+	}
+
+	if len(verifier) == 0 {
+		return contracterr.New(contracterr.InvalidArgument, "verifier must be set")
+	}
+	if !consentScopes[scope] {
+		return contracterr.New(contracterr.InvalidArgument, "unknown consent scope: %s", scope)
+	}
+	if days < 1 {
+		return contracterr.New(contracterr.InvalidArgument, "days must be positive")
+	}
+
+	sender, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	consent := &Consent{
+		Patient:   sender,
+		Verifier:  verifier,
+		Scope:     scope,
+		GrantedAt: now,
+		Until:     now.AddDate(0, 0, days),
+	}
+	return consent.put(ctx)
+}
+
+// RevokeConsent withdraws the consent of the sender to the verifier pseudonym in scope.
+func (c *VaccinationContract) RevokeConsent(ctx contractapi.TransactionContextInterface, verifier, scope string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "RevokeConsent")
+	if err != nil {
+		return err
+	}
+
+	sender, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+	consent, err := getConsent(ctx, sender, verifier, scope)
+	if err != nil {
+		return err
+	}
+	if consent == nil {
+		return contracterr.New(contracterr.NotFound, "%s has no %s consent of %s", verifier, scope, sender)
+	}
+
+	key, err := consentKey(ctx, sender, verifier, scope)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to DelState %s: %w", key, err)
+	}
+	return nil
+}
+
+// isPatientOrStation reports whether the invoking client is patient or the medical station.
+func isPatientOrStation(ctx contractapi.TransactionContextInterface, patient string) (bool, error) {
+	sender, err := getSender(ctx)
+	if err != nil {
+		return false, err
+	}
+	if sender == patient {
+		return true, nil
+	}
+
+	roles, err := clientRoles(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role == RoleDoctor {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorizeRead checks whether the invoking client may read the data of patient in scope:
+// the patient, the medical station and the verifiers with consent may.
+func authorizeRead(ctx contractapi.TransactionContextInterface, patient, scope string) error {
+	ok, err := isPatientOrStation(ctx, patient)
+	if err != nil || ok {
+		return err
+	}
+
+	sender, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	ok, err = hasConsent(ctx, patient, sender, scope, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return contracterr.New(contracterr.Unauthorized, "%s has no %s consent of %s", sender, scope, patient)
+	}
+	return nil
+}
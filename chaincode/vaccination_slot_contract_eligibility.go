@@ -0,0 +1,151 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// Eligibility is returned by GetEligibility.
+//
+// Earliest and Latest bound the dates a slot of Type can be issued on, Latest is missing if there is no upper bound.
+// Previous is the dose the slot has to be issued with, empty if it starts a new series.
+// Missed is the last dose whose interval has passed, the patient starts a new series instead of continuing it.
+// Reason explains why the patient isn't eligible.
+type Eligibility struct {
+	Patient  string           `json:"patient"`
+	Type     VaccinationType  `json:"type"`
+	Eligible bool             `json:"eligible"`
+	Earliest *VaccinationDate `json:"earliest,omitempty"`
+	Latest   *VaccinationDate `json:"latest,omitempty"`
+	Previous string           `json:"previous,omitempty"`
+	SeriesId string           `json:"seriesId,omitempty"`
+	Dose     int              `json:"dose,omitempty"`
+	Missed   string           `json:"missed,omitempty"`
+	Reason   string           `json:"reason,omitempty"`
+}
+
+// GetEligibility returns when the patient pseudonym can get a dose of vaccine.
+//
+// The next dose follows the last administered dose of the patient, and has to be within its dose interval
+// and the interval of the compatibility matrix. After a complete series, or without administered doses,
+// a new series can be started on any date from today.
+// If the interval after the last dose has passed, the dose is missed: its series, or the dose itself if it was
+// issued before series existed, is left incomplete and a new series can be started from today.
+// A patient holding a live slot of vaccine isn't eligible.
+//
+// Only the patient, the medical station and verifiers with a record consent of the patient can read it.
+func (c *VaccinationContract) GetEligibility(ctx contractapi.TransactionContextInterface, patient, vaccine string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetEligibility")
+	if err != nil {
+		return "", err
+	}
+
+	vt, err := parseVaccine(vaccine)
+	if err != nil {
+		return "", err
+	}
+	err = authorizeRead(ctx, patient, ConsentRecord)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := eligibility(ctx, cfg, patient, vt, now)
+	if err != nil {
+		return "", err
+	}
+
+	eligibilityBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(eligibilityBytes), nil
+}
+
+// eligibility computes the Eligibility of patient for vt on the day of now.
+func eligibility(ctx contractapi.TransactionContextInterface, cfg ContractConfig, patient string, vt VaccinationType, now time.Time) (*Eligibility, error) {
+	result := &Eligibility{Patient: patient, Type: vt}
+	today := VaccinationDate(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+
+	slots, err := getSlots(ctx, patient)
+	if err != nil {
+		return nil, err
+	}
+	var last *VaccinationSlot
+	for _, slot := range slots {
+		if slot.IsLive() && slot.Type == vt && !time.Time(slot.Date).Before(time.Time(today)) {
+			result.Reason = fmt.Sprintf("already has slot %s on %s", slot.TokenId, slot.Date)
+			return result, nil
+		}
+		if slot.Status == StatusAdministered && (last == nil || time.Time(slot.Date).After(time.Time(last.Date))) {
+			last = slot
+		}
+	}
+
+	newSeries := last == nil
+	if last != nil && len(last.SeriesId) > 0 {
+		series, err := getSeries(ctx, patient, last.SeriesId)
+		if err != nil {
+			return nil, err
+		}
+		newSeries = last.Dose >= series.PlannedDoses
+	}
+	if newSeries {
+		result.Eligible = true
+		result.Earliest = &today
+		return result, nil
+	}
+
+	min, max := cfg.doseInterval(last.Type)
+	latest := VaccinationDate(time.Time(last.Date).Add(max))
+	if time.Time(latest).Before(time.Time(today)) {
+		result.Eligible = true
+		result.Earliest = &today
+		result.Missed = last.TokenId
+		return result, nil
+	}
+
+	for _, slot := range slots {
+		if slot.IsLive() && len(slot.SeriesId) > 0 && slot.SeriesId == last.SeriesId && slot.Dose > last.Dose {
+			result.Reason = fmt.Sprintf("dose %d of series %s is already issued as %s", slot.Dose, slot.SeriesId, slot.TokenId)
+			return result, nil
+		}
+	}
+
+	if !cfg.compatible(last.Type, vt) {
+		result.Reason = fmt.Sprintf("%s can't follow %s", vt, last.Type)
+		return result, nil
+	}
+
+	earliest := VaccinationDate(time.Time(last.Date).Add(min))
+	if time.Time(earliest).Before(time.Time(today)) {
+		earliest = today
+	}
+	if time.Time(latest).Before(time.Time(earliest)) {
+		result.Reason = fmt.Sprintf("no date after %s on %s is within the dose interval", last.TokenId, last.Date)
+		return result, nil
+	}
+
+	result.Eligible = true
+	result.Earliest = &earliest
+	result.Latest = &latest
+	result.Previous = last.TokenId
+	if len(last.SeriesId) > 0 {
+		result.SeriesId = last.SeriesId
+		result.Dose = last.Dose + 1
+	}
+	return result, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
 )
 
 const (
@@ -23,12 +24,12 @@ func (c *VaccinationContract) emitTransfer(ctx contractapi.TransactionContextInt
 
 	transferEventBytes, err := json.Marshal(transferEvent)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transferEvent: %v", err)
+		return fmt.Errorf("failed to marshal transferEvent: %w", err)
 	}
 
 	err = ctx.GetStub().SetEvent("Transfer", transferEventBytes)
 	if err != nil {
-		return fmt.Errorf("failed to SetEvent transformEventBytes %s: %v", transferEventBytes, err)
+		return fmt.Errorf("failed to SetEvent transformEventBytes %s: %w", transferEventBytes, err)
 	}
 
 	return nil
@@ -72,151 +73,71 @@ func (c *VaccinationContract) emitApprovalForAll(ctx contractapi.TransactionCont
 	return nil
 }
 
-func (c *VaccinationContract) BalanceOf(ctx contractapi.TransactionContextInterface, owner string) int {
+func (c *VaccinationContract) BalanceOf(ctx contractapi.TransactionContextInterface, owner string) (_ int, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "BalanceOf")
+	if err != nil {
+		return 0, err
+	}
+
 	owner64 := base64.StdEncoding.EncodeToString([]byte(owner))
 	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(balancePrefix, []string{owner64})
 	if err != nil {
-		panic("Error creating asset chaincode: " + err.Error())
+		return 0, fmt.Errorf("failed to GetStateByPartialCompositeKey: %w", err)
 	}
+	defer iterator.Close()
 
 	balance := 0
 	for iterator.HasNext() {
 		_, err := iterator.Next()
 		if err != nil {
-			return 0
+			return 0, fmt.Errorf("failure while iterating: %w", err)
 		}
 		balance++
 	}
-	return balance
-}
-
-func (c *VaccinationContract) OwnerOf(ctx contractapi.TransactionContextInterface, tokenId string) (string, error) {
-	vs, err := readVaccinationSlot(ctx, tokenId)
-	if err != nil {
-		return "", err
-	}
-	return vs.Owner, nil
+	return balance, nil
 }
 
-func (c *VaccinationContract) transferFrom(ctx contractapi.TransactionContextInterface, from string, to string, tokenId string) (bool, error) {
-	sender64, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return false, fmt.Errorf("failed to get ClientIdentity: %v", err)
-	}
+func (c *VaccinationContract) OwnerOf(ctx contractapi.TransactionContextInterface, tokenId string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
 
-	senderBytes, err := base64.StdEncoding.DecodeString(sender64)
+	err = c.authorize(ctx, "OwnerOf")
 	if err != nil {
-		return false, fmt.Errorf("failed to decode sender64: %v", err)
+		return "", err
 	}
-	sender := string(senderBytes)
 
 	vs, err := readVaccinationSlot(ctx, tokenId)
 	if err != nil {
-		return false, fmt.Errorf("failed to read vaccination slot: %v", err)
-	}
-
-	owner := vs.Owner
-	operator := vs.Approved
-	operatorApproval, err := c.IsApprovedForAll(ctx, owner, sender)
-	if err != nil {
-		return false, fmt.Errorf("failed to get IsApprovedForAll: %v", err)
-	}
-	if owner != sender && operator != sender && !operatorApproval {
-		return false, fmt.Errorf("the sender is not the current owner nor an authorized operator")
-	}
-
-	if owner != from {
-		return false, fmt.Errorf("the from is not the current owner")
-	}
-
-	vs.Approved = ""
-
-	vs.Owner = to
-	key, err := ctx.GetStub().CreateCompositeKey(vsPrefix, []string{tokenId})
-	if err != nil {
-		return false, fmt.Errorf("failed to create CompositeKey: %v", err)
-	}
-
-	vsBytes, err := json.Marshal(vs)
-	if err != nil {
-		return false, fmt.Errorf("failed to marshal approval: %v", err)
-	}
-
-	err = ctx.GetStub().PutState(key, vsBytes)
-	if err != nil {
-		return false, fmt.Errorf("failed to PutState vsBytes: %v", err)
-	}
-
-	from64 := base64.StdEncoding.EncodeToString([]byte(from))
-	balanceKeyFrom, err := ctx.GetStub().CreateCompositeKey(balancePrefix, []string{from64, tokenId})
-	if err != nil {
-		return false, fmt.Errorf("failed to CreateCompositeKey: %v", err)
-	}
-
-	err = ctx.GetStub().DelState(balanceKeyFrom)
-	if err != nil {
-		return false, fmt.Errorf("failed to DelState balanceKeyFrom %s, %v", balanceKeyFrom, err)
-	}
-
-	to64 := base64.StdEncoding.EncodeToString([]byte(to))
-	balanceKeyTo, err := ctx.GetStub().CreateCompositeKey(balancePrefix, []string{to64, tokenId})
-	if err != nil {
-		return false, fmt.Errorf("failed to create CompositeKey: %v", err)
-	}
-
-	err = ctx.GetStub().PutState(balanceKeyTo, []byte(tokenId))
-	if err != nil {
-		return false, fmt.Errorf("failed to PutState balanceKeyTo %s: %v", balanceKeyTo, err)
-	}
-
-	err = c.emitTransfer(ctx, from, to, tokenId)
-	if err != nil {
-		return false, nil
+		return "", err
 	}
-
-	return true, nil
+	return vs.Owner, nil
 }
 
 func (c *VaccinationContract) approve(ctx contractapi.TransactionContextInterface, operator string, tokenId string) (bool, error) {
-	sender64, err := ctx.GetClientIdentity().GetID()
+	sender, err := getSender(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to get client identity: %v", err)
-	}
-
-	senderBytes, err := base64.StdEncoding.DecodeString(sender64)
-	if err != nil {
-		return false, fmt.Errorf("failed to decode sender64: %v", err)
+		return false, err
 	}
-	sender := string(senderBytes)
 
 	vs, err := readVaccinationSlot(ctx, tokenId)
 	if err != nil {
-		return false, fmt.Errorf("failed to read vaccination slot: %v", err)
+		return false, err
 	}
 
 	owner := vs.Owner
-	operatorApproval, err := c.IsApprovedForAll(ctx, owner, sender)
+	operatorApproval, err := isApprovedForAll(ctx, owner, sender)
 	if err != nil {
-		return false, fmt.Errorf("failed to get IsApprovedForAll: %v", err)
+		return false, contracterr.Annotate(err, "failed to get IsApprovedForAll")
 	}
 	if owner != sender && !operatorApproval {
-		return false, fmt.Errorf("the sender is not the current owner nor an authorized oeprator")
+		return false, contracterr.New(contracterr.Unauthorized, "the sender is not the current owner nor an authorized operator")
 	}
 
 	vs.Approved = operator
-	key, err := ctx.GetStub().CreateCompositeKey(vsPrefix, []string{tokenId})
+	err = vs.put(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to create CompositeKey %s: %v", tokenId, err)
-	}
-
-	vsBytes, err := json.Marshal(vs)
-	if err != nil {
-		return false, fmt.Errorf("failed to marshal vsBytes: %v", err)
-	}
-
-	err = ctx.GetStub().PutState(key, vsBytes)
-	if err != nil {
-		return false, fmt.Errorf("failed to PutState for key: %v", err)
+		return false, err
 	}
 
 	err = c.emitApproval(ctx, vs.Owner, operator, tokenId)
@@ -228,36 +149,31 @@ func (c *VaccinationContract) approve(ctx contractapi.TransactionContextInterfac
 }
 
 func (c *VaccinationContract) setApprovalForAll(ctx contractapi.TransactionContextInterface, operator string, approved bool) (bool, error) {
-	sender64, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return false, fmt.Errorf("failed to get client identity: %v", err)
-	}
-
-	senderBytes, err := base64.StdEncoding.DecodeString(sender64)
+	sender, err := getSender(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to decode sender64: %v", err)
+		return false, err
 	}
-	sender := string(senderBytes)
 
 	vsApproval := &ApprovalForAll{
 		Owner:    sender,
 		Operator: operator,
 		Approved: approved,
+		Version:  SchemaVersion,
 	}
 
 	approvalKey, err := ctx.GetStub().CreateCompositeKey(approvalPrefix, []string{sender, operator})
 	if err != nil {
-		return false, fmt.Errorf("failed to create CompositeKey: %v", err)
+		return false, fmt.Errorf("failed to create CompositeKey: %w", err)
 	}
 
 	approvalBytes, err := json.Marshal(vsApproval)
 	if err != nil {
-		return false, fmt.Errorf("failed to marshal vsApproval: %v", err)
+		return false, fmt.Errorf("failed to marshal vsApproval: %w", err)
 	}
 
 	err = ctx.GetStub().PutState(approvalKey, approvalBytes)
 	if err != nil {
-		return false, fmt.Errorf("failed to putState approvalBytes: %v", err)
+		return false, fmt.Errorf("failed to putState approvalBytes: %w", err)
 	}
 
 	err = c.emitApprovalForAll(ctx, sender, operator, approved)
@@ -268,22 +184,39 @@ func (c *VaccinationContract) setApprovalForAll(ctx contractapi.TransactionConte
 	return true, nil
 }
 
-func (c *VaccinationContract) GetApproved(ctx contractapi.TransactionContextInterface, tokenId string) (string, error) {
+func (c *VaccinationContract) GetApproved(ctx contractapi.TransactionContextInterface, tokenId string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetApproved")
+	if err != nil {
+		return "", err
+	}
+
 	vs, err := readVaccinationSlot(ctx, tokenId)
 	if err != nil {
-		return "", fmt.Errorf("failed GetApproved for tokenId: %v", err)
+		return "", err
 	}
 	return vs.Approved, nil
 }
 
-func (c *VaccinationContract) IsApprovedForAll(ctx contractapi.TransactionContextInterface, owner string, operator string) (bool, error) {
+func (c *VaccinationContract) IsApprovedForAll(ctx contractapi.TransactionContextInterface, owner string, operator string) (_ bool, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "IsApprovedForAll")
+	if err != nil {
+		return false, err
+	}
+	return isApprovedForAll(ctx, owner, operator)
+}
+
+func isApprovedForAll(ctx contractapi.TransactionContextInterface, owner string, operator string) (bool, error) {
 	approvalKey, err := ctx.GetStub().CreateCompositeKey(approvalPrefix, []string{owner, operator})
 	if err != nil {
-		return false, fmt.Errorf("failed to create CompositeKey: %v", err)
+		return false, fmt.Errorf("failed to create CompositeKey: %w", err)
 	}
 	approvalBytes, err := ctx.GetStub().GetState(approvalKey)
 	if err != nil {
-		return false, fmt.Errorf("failed to GetState approvalBytes: %v", err)
+		return false, fmt.Errorf("failed to GetState approvalBytes: %w", err)
 	}
 
 	if len(approvalBytes) < 1 {
@@ -291,9 +224,9 @@ func (c *VaccinationContract) IsApprovedForAll(ctx contractapi.TransactionContex
 	}
 
 	approval := &ApprovalForAll{}
-	err = json.Unmarshal(approvalBytes, approval)
+	err = decodeRecord(approvalPrefix, approvalBytes, approval)
 	if err != nil {
-		return false, fmt.Errorf("failed to unmarshal: %v, string %s", err, string(approvalBytes))
+		return false, contracterr.Annotate(err, "failed to unmarshal %s", string(approvalBytes))
 	}
 
 	return approval.Approved, nil
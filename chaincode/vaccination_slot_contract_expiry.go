@@ -0,0 +1,187 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// ExpiredSlot is a slot changed by ExpireSlots.
+type ExpiredSlot struct {
+	TokenId string     `json:"tokenId"`
+	Owner   string     `json:"owner"`
+	Status  SlotStatus `json:"status"`
+}
+
+// SlotsExpired is emitted by ExpireSlots if any slot has been expired.
+type SlotsExpired struct {
+	AsOf  VaccinationDate `json:"asOf"`
+	Slots []ExpiredSlot   `json:"slots"`
+}
+
+// ExpiryResult is returned by ExpireSlots.
+//
+// Bookmark is the last examined key, it has to be passed to the next call until Done is true.
+type ExpiryResult struct {
+	Examined int           `json:"examined"`
+	Slots    []ExpiredSlot `json:"slots"`
+	Bookmark string        `json:"bookmark"`
+	Done     bool          `json:"done"`
+}
+
+// ExpireSlots examines at most pageSize slots and ends the ones dated before asOf that were never administered.
+//
+// Issued slots of patients become no-show, cancelled slots of the pool become expired.
+// Offers of the owner referencing an ended slot are deleted. Emits a SlotsExpired event.
+//
+// AsOf is in 2006-01-02 format and can't be after the transaction date, empty means the transaction date.
+// Ended slots are skipped, so a page can be repeated safely.
+func (c *VaccinationContract) ExpireSlots(ctx contractapi.TransactionContextInterface, asOf string, pageSize int, bookmark string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "ExpireSlots")
+	if err != nil {
+		return "", err
+	}
+
+	if pageSize < 1 {
+		return "", contracterr.New(contracterr.InvalidArgument, "pageSize must be positive")
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	cutoff := VaccinationDate(now.UTC().Truncate(24 * time.Hour))
+	if len(asOf) > 0 {
+		cutoff, err = parseDate(asOf)
+		if err != nil {
+			return "", err
+		}
+		if time.Time(cutoff).After(now) {
+			return "", contracterr.New(contracterr.InvalidArgument, "asOf %s is after the transaction time", asOf)
+		}
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	result := &ExpiryResult{Slots: make([]ExpiredSlot, 0), Bookmark: bookmark}
+	result.Done, err = c.expirePage(ctx, cfg, cutoff, pageSize, result)
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Slots) > 0 {
+		err = emitSlotsExpired(ctx, cutoff, result.Slots)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+// expirePage ends the slots of the page after result.Bookmark.
+// It reports whether every slot has been examined.
+//
+// Paginated queries are only allowed in read-only transactions,
+// so the iteration starts from the first slot and skips the keys up to the bookmark.
+func (c *VaccinationContract) expirePage(ctx contractapi.TransactionContextInterface, cfg ContractConfig, cutoff VaccinationDate, pageSize int, result *ExpiryResult) (bool, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(vsPrefix, []string{})
+	if err != nil {
+		return false, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", vsPrefix, err)
+	}
+	defer iterator.Close()
+
+	after := result.Bookmark
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return false, fmt.Errorf("failure while iterating: %w", err)
+		}
+		if len(after) > 0 && kv.Key <= after {
+			continue
+		}
+		if result.Examined == pageSize {
+			return false, nil
+		}
+		result.Examined++
+		result.Bookmark = kv.Key
+
+		slot := &VaccinationSlot{}
+		err = decodeRecord(vsPrefix, kv.Value, slot)
+		if err != nil {
+			return false, fmt.Errorf("failed to decode %s: %w", kv.Key, err)
+		}
+		if !time.Time(slot.Date).Before(time.Time(cutoff)) {
+			continue
+		}
+
+		var status SlotStatus
+		switch {
+		case slot.Status == StatusIssued:
+			status = StatusNoShow
+		case slot.Status == StatusCancelled && slot.Owner == cfg.PoolIdentity:
+			status = StatusExpired
+		default:
+			continue
+		}
+
+		err = expireSlot(ctx, slot, status)
+		if err != nil {
+			return false, err
+		}
+		result.Slots = append(result.Slots, ExpiredSlot{
+			TokenId: slot.TokenId,
+			Owner:   slot.Owner,
+			Status:  status,
+		})
+	}
+	return true, nil
+}
+
+// expireSlot moves the slot to status, removes it from the balance of its owner and deletes the offers referencing it.
+func expireSlot(ctx contractapi.TransactionContextInterface, slot *VaccinationSlot, status SlotStatus) error {
+	err := slot.transition(ctx, status)
+	if err != nil {
+		return err
+	}
+	err = slot.delBalance(ctx)
+	if err != nil {
+		return err
+	}
+	err = slot.put(ctx)
+	if err != nil {
+		return err
+	}
+
+	return delSlotOffers(ctx, slot)
+}
+
+func emitSlotsExpired(ctx contractapi.TransactionContextInterface, asOf VaccinationDate, slots []ExpiredSlot) error {
+	event := &SlotsExpired{
+		AsOf:  asOf,
+		Slots: slots,
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SlotsExpired: %w", err)
+	}
+
+	err = ctx.GetStub().SetEvent("SlotsExpired", eventBytes)
+	if err != nil {
+		return fmt.Errorf("failed to SetEvent SlotsExpired: %w", err)
+	}
+	return nil
+}
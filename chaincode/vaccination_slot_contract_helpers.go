@@ -4,24 +4,30 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"time"
+
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
 )
 
 func readVaccinationSlot(ctx contractapi.TransactionContextInterface, tokenId string) (*VaccinationSlot, error) {
 	key, err := ctx.GetStub().CreateCompositeKey(vsPrefix, []string{tokenId})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create CompositeKey %s: %v", tokenId, err)
+		return nil, fmt.Errorf("failed to create CompositeKey %s: %w", tokenId, err)
 	}
 
 	vsBytes, err := ctx.GetStub().GetState(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get state %s: %v", key, err)
+		return nil, fmt.Errorf("failed to get state %s: %w", key, err)
+	}
+	if len(vsBytes) == 0 {
+		return nil, contracterr.New(contracterr.NotFound, "slot %s doesn't exist", tokenId)
 	}
 
 	vs := &VaccinationSlot{}
-	err = json.Unmarshal(vsBytes, vs)
+	err = decodeRecord(vsPrefix, vsBytes, vs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal vsBytes: %v", err)
+		return nil, contracterr.Annotate(err, "failed to decode vsBytes")
 	}
 
 	return vs, nil
@@ -30,37 +36,46 @@ func readVaccinationSlot(ctx contractapi.TransactionContextInterface, tokenId st
 func vaccinationSlotExists(ctx contractapi.TransactionContextInterface, tokenId string) (bool, error) {
 	key, err := ctx.GetStub().CreateCompositeKey(vsPrefix, []string{tokenId})
 	if err != nil {
-		return false, fmt.Errorf("failed to create CompositeKey %s: %v", tokenId, err)
+		return false, fmt.Errorf("failed to create CompositeKey %s: %w", tokenId, err)
 	}
 
 	vsBytes, err := ctx.GetStub().GetState(key)
 	if err != nil {
-		return false, fmt.Errorf("failed to get state %s: %v", key, err)
+		return false, fmt.Errorf("failed to get state %s: %w", key, err)
 	}
 
 	return len(vsBytes) > 0, nil
 }
 
-func getSender(ctx contractapi.TransactionContextInterface) (string, error) {
-	//sender64, err := ctx.GetClientIdentity().GetID()
+// getClientId returns the decoded identity of the invoking client.
+func getClientId(ctx contractapi.TransactionContextInterface) (string, error) {
 	id := ctx.GetClientIdentity()
 	sender64, err := id.GetID()
 	if err != nil {
-		return "", fmt.Errorf("failed to get ClientIdentity: %v", err)
+		return "", fmt.Errorf("failed to get ClientIdentity: %w", err)
 	}
 
 	senderBytes, err := base64.StdEncoding.DecodeString(sender64)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode sender64: %v", err)
+		return "", fmt.Errorf("failed to decode sender64: %w", err)
+	}
+	return string(senderBytes), nil
+}
+
+// getSender resolves the invoking client to its pseudonym.
+func getSender(ctx contractapi.TransactionContextInterface) (string, error) {
+	clientId, err := getClientId(ctx)
+	if err != nil {
+		return "", err
 	}
-	sender := string(senderBytes)
-	return sender, nil
+	return pseudonym(ctx, clientId)
 }
 
 func putOffer(ctx contractapi.TransactionContextInterface, offer TradeOffer) error {
 	sender64 := base64.StdEncoding.EncodeToString([]byte(offer.Sender))
 	recipient64 := base64.StdEncoding.EncodeToString([]byte(offer.Recipient))
 
+	offer.Version = SchemaVersion
 	offerBytes, err := json.Marshal(&offer)
 	if err != nil {
 		return err
@@ -111,6 +126,7 @@ func getOffers(ctx contractapi.TransactionContextInterface, identity string) ([]
 	if err != nil {
 		return offers, err
 	}
+	defer iterator.Close()
 
 	for iterator.HasNext() {
 		offerKV, err := iterator.Next()
@@ -118,7 +134,7 @@ func getOffers(ctx contractapi.TransactionContextInterface, identity string) ([]
 			return offers, err
 		}
 		offer := &TradeOffer{}
-		err = json.Unmarshal(offerKV.Value, offer)
+		err = decodeRecord(offerPrefix, offerKV.Value, offer)
 		if err != nil {
 			return offers, err
 		}
@@ -138,7 +154,10 @@ func getOffer(ctx contractapi.TransactionContextInterface, offerUuid string) (Tr
 	if err != nil {
 		return offer, err
 	}
-	err = json.Unmarshal(offerBytes, &offer)
+	if len(offerBytes) == 0 {
+		return offer, contracterr.New(contracterr.NotFound, "offer %s doesn't exist", offerUuid)
+	}
+	err = decodeRecord(offerPrefix, offerBytes, &offer)
 	if err != nil {
 		return offer, err
 	}
@@ -181,24 +200,68 @@ func delOffer(ctx contractapi.TransactionContextInterface, offer TradeOffer) err
 	return nil
 }
 
+// delSlotOffers deletes the offers of the slot owner referencing the slot.
+func delSlotOffers(ctx contractapi.TransactionContextInterface, slot *VaccinationSlot) error {
+	offers, err := getOffers(ctx, slot.Owner)
+	if err != nil {
+		return contracterr.Annotate(err, "failed to get offers of %s", slot.Owner)
+	}
+	for _, offer := range offers {
+		if offer.SenderItem != slot.TokenId && offer.RecipientItem != slot.TokenId {
+			continue
+		}
+		err = offer.del(ctx)
+		if err != nil {
+			return contracterr.Annotate(err, "failed to delete offer %s", offer.Uuid)
+		}
+	}
+	return nil
+}
+
 func (offer TradeOffer) del(ctx contractapi.TransactionContextInterface) error {
 	return delOffer(ctx, offer)
 }
 
+// checkInterval validates that date is within the [min, max] dose interval after the previous dose.
+// Without a previous dose every date is accepted.
+func checkInterval(ctx contractapi.TransactionContextInterface, cfg ContractConfig, previous string, date VaccinationDate) error {
+	if len(previous) == 0 {
+		return nil
+	}
+	prev, err := readVaccinationSlot(ctx, previous)
+	if err != nil {
+		return contracterr.Annotate(err, "previous slot present but can't be read")
+	}
+	if !prev.Type.IsValid() {
+		return contracterr.New(contracterr.InvalidArgument, "can't find dose interval for: %s", string(prev.Type))
+	}
+
+	min, max := cfg.doseInterval(prev.Type)
+	gap := time.Time(date).Sub(time.Time(prev.Date))
+	if gap < min {
+		return contracterr.New(contracterr.RuleViolation, "date %s is too early, the dose after %s on %s must be at least %d days later", date, prev.Type, prev.Date, int(min.Hours()/24))
+	}
+	if gap > max {
+		return contracterr.New(contracterr.RuleViolation, "date %s is too late, the dose after %s on %s must be at most %d days later", date, prev.Type, prev.Date, int(max.Hours()/24))
+	}
+	return nil
+}
+
 func (slot *VaccinationSlot) put(ctx contractapi.TransactionContextInterface) error {
 	key, err := ctx.GetStub().CreateCompositeKey(vsPrefix, []string{slot.TokenId})
 	if err != nil {
-		return fmt.Errorf("failed to create CompositeKey: %v", err)
+		return fmt.Errorf("failed to create CompositeKey: %w", err)
 	}
 
+	slot.Version = SchemaVersion
 	vsBytes, err := json.Marshal(slot)
 	if err != nil {
-		return fmt.Errorf("failed to marshal approval: %v", err)
+		return fmt.Errorf("failed to marshal approval: %w", err)
 	}
 
 	err = ctx.GetStub().PutState(key, vsBytes)
 	if err != nil {
-		return fmt.Errorf("failed to PutState vsBytes: %v", err)
+		return fmt.Errorf("failed to PutState vsBytes: %w", err)
 	}
 	return nil
 }
@@ -207,11 +270,11 @@ func (slot *VaccinationSlot) putBalance(ctx contractapi.TransactionContextInterf
 	owner64 := base64.StdEncoding.EncodeToString([]byte(slot.Owner))
 	key, err := ctx.GetStub().CreateCompositeKey(balancePrefix, []string{owner64, slot.TokenId})
 	if err != nil {
-		return fmt.Errorf("failed to CreateCompositeKey: %v", err)
+		return fmt.Errorf("failed to CreateCompositeKey: %w", err)
 	}
 	err = ctx.GetStub().PutState(key, []byte(slot.TokenId))
 	if err != nil {
-		return fmt.Errorf("failed to PutState balanceKeyTo %s: %v", key, err)
+		return fmt.Errorf("failed to PutState balanceKeyTo %s: %w", key, err)
 	}
 	return nil
 }
@@ -220,11 +283,11 @@ func (slot *VaccinationSlot) delBalance(ctx contractapi.TransactionContextInterf
 	owner64 := base64.StdEncoding.EncodeToString([]byte(slot.Owner))
 	key, err := ctx.GetStub().CreateCompositeKey(balancePrefix, []string{owner64, slot.TokenId})
 	if err != nil {
-		return fmt.Errorf("failed to CreateCompositeKey: %v", err)
+		return fmt.Errorf("failed to CreateCompositeKey: %w", err)
 	}
 	err = ctx.GetStub().DelState(key)
 	if err != nil {
-		return fmt.Errorf("failed to DelState balanceKeyFrom %s, %v", key, err)
+		return fmt.Errorf("failed to DelState balanceKeyFrom %s, %w", key, err)
 	}
 	return nil
 }
@@ -0,0 +1,152 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// HistoryEntry is a committed change of a key.
+type HistoryEntry struct {
+	TxId      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	IsDelete  bool      `json:"isDelete"`
+}
+
+// SlotHistoryEntry is a version of a slot returned by GetSlotHistory.
+// Value is nil if the entry is a deletion.
+type SlotHistoryEntry struct {
+	HistoryEntry
+	Value *VaccinationSlot `json:"value"`
+}
+
+// OfferHistoryEntry is a version of an offer returned by GetOfferHistory.
+// Value is nil if the entry is a deletion.
+type OfferHistoryEntry struct {
+	HistoryEntry
+	Value *TradeOffer `json:"value"`
+}
+
+// getHistory returns the changes of the key of prefix and attributes from the oldest to the newest,
+// calling decode with the value of every change that isn't a deletion.
+//
+// Fabric returns the history from the newest to the oldest in commit order, so the changes are reversed.
+// They aren't sorted by timestamp, since the timestamp is set by the client and doesn't follow the commit order.
+func getHistory(ctx contractapi.TransactionContextInterface, prefix string, attributes []string, decode func(entry HistoryEntry, value []byte) error) error {
+	key, err := ctx.GetStub().CreateCompositeKey(prefix, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+
+	iterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to GetHistoryForKey %s: %w", key, err)
+	}
+	defer iterator.Close()
+
+	type change struct {
+		entry HistoryEntry
+		value []byte
+	}
+	changes := make([]change, 0)
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return fmt.Errorf("failure while iterating: %w", err)
+		}
+
+		c := change{entry: HistoryEntry{
+			TxId:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}}
+		if modification.Timestamp != nil {
+			c.entry.Timestamp = modification.Timestamp.AsTime()
+		}
+		if !modification.IsDelete {
+			c.value = modification.Value
+		}
+		changes = append(changes, c)
+	}
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+
+	for _, c := range changes {
+		err = decode(c.entry, c.value)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s in %s: %w", key, c.entry.TxId, err)
+		}
+	}
+	return nil
+}
+
+// GetSlotHistory returns every committed version of the slot from the oldest to the newest.
+// Older versions are upgraded to the current schema.
+func (c *VaccinationContract) GetSlotHistory(ctx contractapi.TransactionContextInterface, slotUuid string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetSlotHistory")
+	if err != nil {
+		return "", err
+	}
+
+	history := make([]SlotHistoryEntry, 0)
+	err = getHistory(ctx, vsPrefix, []string{slotUuid}, func(entry HistoryEntry, value []byte) error {
+		item := SlotHistoryEntry{HistoryEntry: entry}
+		if value != nil {
+			item.Value = &VaccinationSlot{}
+			err := decodeRecord(vsPrefix, value, item.Value)
+			if err != nil {
+				return err
+			}
+		}
+		history = append(history, item)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	historyBytes, err := json.Marshal(history)
+	if err != nil {
+		return "", err
+	}
+	return string(historyBytes), nil
+}
+
+// GetOfferHistory returns every committed version of the offer from the oldest to the newest.
+// The last entry is a deletion if the offer has been accepted or deleted.
+func (c *VaccinationContract) GetOfferHistory(ctx contractapi.TransactionContextInterface, offerUuid string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetOfferHistory")
+	if err != nil {
+		return "", err
+	}
+
+	history := make([]OfferHistoryEntry, 0)
+	err = getHistory(ctx, offerPrefix, []string{offerUuid}, func(entry HistoryEntry, value []byte) error {
+		item := OfferHistoryEntry{HistoryEntry: entry}
+		if value != nil {
+			item.Value = &TradeOffer{}
+			err := decodeRecord(offerPrefix, value, item.Value)
+			if err != nil {
+				return err
+			}
+		}
+		history = append(history, item)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	historyBytes, err := json.Marshal(history)
+	if err != nil {
+		return "", err
+	}
+	return string(historyBytes), nil
+}
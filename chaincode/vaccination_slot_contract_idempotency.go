@@ -0,0 +1,224 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const requestPrefix = "request"
+
+// transientRequestId is the optional transient key holding the client request id
+// of IssueSlot, IssueSlotAt, IssueSlotTransient, IssueSlots and MakeOffer.
+const transientRequestId = "requestId"
+
+// defaultRequestRetentionDays is used when the configuration doesn't set the retention.
+const defaultRequestRetentionDays = 30
+
+// RequestRecord stores the result of a transaction invoked with a client request id,
+// so a retry with the same id returns the original result instead of repeating the transaction.
+// ArgsHash identifies the arguments, a retry with other arguments is a conflict.
+//
+// Records are stored as request.caller.requestId and kept until PurgeRequests removes them.
+type RequestRecord struct {
+	Caller      string    `json:"caller"`
+	RequestId   string    `json:"requestId"`
+	Transaction string    `json:"transaction"`
+	ArgsHash    string    `json:"argsHash,omitempty"`
+	Result      string    `json:"result"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Version     int       `json:"schemaVersion"`
+}
+
+// PurgeResult is returned by PurgeRequests.
+//
+// Bookmark is the last examined key, it has to be passed to the next call until Done is true.
+type PurgeResult struct {
+	Examined int    `json:"examined"`
+	Purged   int    `json:"purged"`
+	Bookmark string `json:"bookmark"`
+	Done     bool   `json:"done"`
+}
+
+// requestId returns the client request id of the transient map, empty if there is none.
+func requestId(ctx contractapi.TransactionContextInterface) (string, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transient map: %w", err)
+	}
+	return string(transient[transientRequestId]), nil
+}
+
+func requestKey(ctx contractapi.TransactionContextInterface, caller, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(requestPrefix, []string{caller, id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+// argsHash returns the hex SHA-256 hash of the transaction arguments.
+func argsHash(args []string) string {
+	hash := sha256.New()
+	for _, arg := range args {
+		hash.Write([]byte(arg))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replayRequest returns the stored result of tx if the client passed a request id that has already been used
+// with the same arguments. Patients must be passed as pseudonyms, so the hash doesn't disclose them.
+// The returned record has to be completed with saveRequest after a successful transaction,
+// it's nil if the client didn't pass a request id.
+//
+// Records stored before the arguments were hashed are replayed without comparing the arguments.
+func replayRequest(ctx contractapi.TransactionContextInterface, tx string, args ...string) (*RequestRecord, bool, error) {
+	id, err := requestId(ctx)
+	if err != nil || len(id) == 0 {
+		return nil, false, err
+	}
+
+	caller, err := getSender(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := requestKey(ctx, caller, id)
+	if err != nil {
+		return nil, false, err
+	}
+	recordBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get state %s: %w", key, err)
+	}
+	hash := argsHash(args)
+
+	if len(recordBytes) > 0 {
+		record := &RequestRecord{}
+		err = decodeRecord(requestPrefix, recordBytes, record)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decode %s: %w", key, err)
+		}
+		if record.Transaction != tx {
+			return nil, false, contracterr.New(contracterr.Conflict, "request id %s has already been used for %s", id, record.Transaction)
+		}
+		if len(record.ArgsHash) > 0 && record.ArgsHash != hash {
+			return nil, false, contracterr.New(contracterr.Conflict, "request id %s has already been used with other arguments", id)
+		}
+		return record, true, nil
+	}
+
+	return &RequestRecord{
+		Caller:      caller,
+		RequestId:   id,
+		Transaction: tx,
+		ArgsHash:    hash,
+	}, false, nil
+}
+
+// saveRequest stores the result of a transaction invoked with a request id.
+// It does nothing for a nil record.
+func saveRequest(ctx contractapi.TransactionContextInterface, record *RequestRecord, result string) error {
+	if record == nil {
+		return nil
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	record.Result = result
+	record.CreatedAt = now
+	record.Version = SchemaVersion
+
+	key, err := requestKey(ctx, record.Caller, record.RequestId)
+	if err != nil {
+		return err
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request record: %w", err)
+	}
+	err = ctx.GetStub().PutState(key, recordBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+// PurgeRequests examines at most pageSize request records
+// and deletes the ones older than the retention of the configuration.
+//
+// Paginated queries are only allowed in read-only transactions,
+// so every page iterates from the first record and skips the keys up to the bookmark.
+func (c *VaccinationContract) PurgeRequests(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "PurgeRequests")
+	if err != nil {
+		return "", err
+	}
+
+	if pageSize < 1 {
+		return "", contracterr.New(contracterr.InvalidArgument, "pageSize must be positive")
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	cutoff := now.AddDate(0, 0, -cfg.RequestRetentionDays)
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(requestPrefix, []string{})
+	if err != nil {
+		return "", fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", requestPrefix, err)
+	}
+	defer iterator.Close()
+
+	result := &PurgeResult{Bookmark: bookmark, Done: true}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return "", fmt.Errorf("failure while iterating: %w", err)
+		}
+		if len(bookmark) > 0 && kv.Key <= bookmark {
+			continue
+		}
+		if result.Examined == pageSize {
+			result.Done = false
+			break
+		}
+		result.Examined++
+		result.Bookmark = kv.Key
+
+		record := &RequestRecord{}
+		err = decodeRecord(requestPrefix, kv.Value, record)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode %s: %w", kv.Key, err)
+		}
+		if !record.CreatedAt.Before(cutoff) {
+			continue
+		}
+
+		err = ctx.GetStub().DelState(kv.Key)
+		if err != nil {
+			return "", fmt.Errorf("failed to DelState %s: %w", kv.Key, err)
+		}
+		result.Purged++
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
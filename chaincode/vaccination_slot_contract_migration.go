@@ -0,0 +1,126 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// migrationPrefixes are migrated in this order by MigrateState.
+var migrationPrefixes = []string{configPrefix, vsPrefix, offerPrefix, approvalPrefix, waitlistPrefix, requestPrefix, tradeCountPrefix, seriesPrefix, consentPrefix, verificationPrefix}
+
+// MigrationResult is returned by MigrateState.
+//
+// Bookmark is the last examined key, it has to be passed to the next call until Done is true.
+type MigrationResult struct {
+	Examined int    `json:"examined"`
+	Migrated int    `json:"migrated"`
+	Bookmark string `json:"bookmark"`
+	Done     bool   `json:"done"`
+}
+
+// MigrateState rewrites at most pageSize stored records of fromVersion in the current SchemaVersion.
+// Records of other versions are left untouched.
+//
+// Paginated queries are only allowed in read-only transactions,
+// so every page iterates from the beginning of the current prefix and skips the keys up to the bookmark.
+func (c *VaccinationContract) MigrateState(ctx contractapi.TransactionContextInterface, fromVersion int, pageSize int, bookmark string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "MigrateState")
+	if err != nil {
+		return "", err
+	}
+
+	if fromVersion < schemaVersionLegacy || fromVersion >= SchemaVersion {
+		return "", contracterr.New(contracterr.InvalidArgument, "can't migrate from schema version %d to %d", fromVersion, SchemaVersion)
+	}
+	if pageSize < 1 {
+		return "", contracterr.New(contracterr.InvalidArgument, "pageSize must be positive")
+	}
+
+	phase := 0
+	if len(bookmark) > 0 {
+		prefix, _, err := ctx.GetStub().SplitCompositeKey(bookmark)
+		if err != nil {
+			return "", contracterr.New(contracterr.InvalidArgument, "invalid bookmark: %v", err)
+		}
+		for phase < len(migrationPrefixes) && migrationPrefixes[phase] != prefix {
+			phase++
+		}
+		if phase == len(migrationPrefixes) {
+			return "", contracterr.New(contracterr.InvalidArgument, "invalid bookmark prefix: %s", prefix)
+		}
+	}
+
+	result := &MigrationResult{Bookmark: bookmark}
+	for after := bookmark; phase < len(migrationPrefixes); phase++ {
+		done, err := migratePrefix(ctx, migrationPrefixes[phase], after, fromVersion, pageSize, result)
+		if err != nil {
+			return "", err
+		}
+		if !done {
+			break
+		}
+		after = ""
+	}
+	result.Done = phase == len(migrationPrefixes)
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+// migratePrefix migrates the records of prefix after the key after until pageSize records are examined.
+// It reports whether every record of prefix has been examined.
+func migratePrefix(ctx contractapi.TransactionContextInterface, prefix, after string, fromVersion int, pageSize int, result *MigrationResult) (bool, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(prefix, []string{})
+	if err != nil {
+		return false, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", prefix, err)
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return false, fmt.Errorf("failure while iterating: %w", err)
+		}
+		if len(after) > 0 && kv.Key <= after {
+			continue
+		}
+		if result.Examined == pageSize {
+			return false, nil
+		}
+		result.Examined++
+		result.Bookmark = kv.Key
+
+		version, err := recordVersion(kv.Value)
+		if err != nil {
+			return false, fmt.Errorf("failed to read version of %s: %w", kv.Key, err)
+		}
+		if version != fromVersion {
+			continue
+		}
+
+		upgraded, err := upgradeRecord(prefix, kv.Value)
+		if err != nil {
+			return false, fmt.Errorf("failed to upgrade %s: %w", kv.Key, err)
+		}
+		err = ctx.GetStub().PutState(kv.Key, upgraded)
+		if err != nil {
+			return false, fmt.Errorf("failed to PutState %s: %w", kv.Key, err)
+		}
+		if hook, ok := migrationHooks[prefix]; ok {
+			err = hook(ctx, upgraded)
+			if err != nil {
+				return false, fmt.Errorf("failed to migrate %s: %w", kv.Key, err)
+			}
+		}
+		result.Migrated++
+	}
+	return true, nil
+}
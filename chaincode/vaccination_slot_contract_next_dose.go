@@ -0,0 +1,128 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// issueNextDose issues the next dose of the series of an administered slot if its vaccine type has autoNextDose.
+// Emits a NextDoseIssued event, or a NextDoseUnscheduled event if there is no free date.
+func (c *VaccinationContract) issueNextDose(ctx contractapi.TransactionContextInterface, slot *VaccinationSlot) error {
+	if len(slot.SeriesId) == 0 {
+		return nil
+	}
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !cfg.Vaccines[slot.Type].AutoNextDose {
+		return nil
+	}
+	series, err := getSeries(ctx, slot.Owner, slot.SeriesId)
+	if err != nil {
+		return err
+	}
+	if slot.Dose >= series.PlannedDoses {
+		return nil
+	}
+
+	date, ok, err := nextDoseDate(ctx, cfg, slot)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return emitNextDoseEvent(ctx, "NextDoseUnscheduled", &NextDoseUnscheduled{
+			Owner:    slot.Owner,
+			Previous: slot.TokenId,
+			SeriesId: slot.SeriesId,
+			Dose:     slot.Dose + 1,
+			Type:     slot.Type,
+		})
+	}
+
+	// The administered status of slot isn't visible to reads yet.
+	pending := newPendingMoves()
+	pending.administered[slot.TokenId] = true
+	vs, err := c.checkIssue(ctx, cfg, string(slot.Type), date.String(), slot.Site, slot.Owner, slot.TokenId, pending)
+	if err != nil {
+		return err
+	}
+	err = c.mintSlot(ctx, cfg, vs)
+	if err != nil {
+		return err
+	}
+
+	return emitNextDoseEvent(ctx, "NextDoseIssued", &NextDoseIssued{
+		Owner:    slot.Owner,
+		Previous: slot.TokenId,
+		SeriesId: slot.SeriesId,
+		Dose:     vs.Dose,
+		TokenId:  vs.TokenId,
+		Type:     vs.Type,
+		Date:     vs.Date,
+		Site:     vs.Site,
+	})
+}
+
+// nextDoseDate returns the first date of the dose interval after slot on which the owner has no other slot
+// and the site of slot has a free place, there is none if the compatibility matrix doesn't allow the type again.
+func nextDoseDate(ctx contractapi.TransactionContextInterface, cfg ContractConfig, slot *VaccinationSlot) (VaccinationDate, bool, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return VaccinationDate{}, false, err
+	}
+	slots, err := getSlots(ctx, slot.Owner)
+	if err != nil {
+		return VaccinationDate{}, false, err
+	}
+	occupied := make(map[string]bool)
+	for _, vs := range slots {
+		if vs.occupies() {
+			occupied[vs.Date.String()] = true
+		}
+	}
+
+	err = checkCompatibility(ctx, cfg, slot.TokenId, slot.Type)
+	if contracterr.CodeOf(err) == contracterr.RuleViolation {
+		return VaccinationDate{}, false, nil
+	}
+	if err != nil {
+		return VaccinationDate{}, false, err
+	}
+
+	min, max := cfg.doseInterval(slot.Type)
+	from := time.Time(slot.Date).Add(min)
+	to := time.Time(slot.Date).Add(max)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := VaccinationDate(day)
+		if day.Before(now) || occupied[date.String()] {
+			continue
+		}
+		free, err := hasCapacity(ctx, cfg, slot.Site, date, 0)
+		if err != nil {
+			return VaccinationDate{}, false, err
+		}
+		if free {
+			return date, true, nil
+		}
+	}
+	return VaccinationDate{}, false, nil
+}
+
+// emitNextDoseEvent sets the NextDoseIssued or NextDoseUnscheduled event of the transaction.
+func emitNextDoseEvent(ctx contractapi.TransactionContextInterface, name string, event interface{}) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+
+	err = ctx.GetStub().SetEvent(name, eventBytes)
+	if err != nil {
+		return fmt.Errorf("failed to SetEvent %s: %w", name, err)
+	}
+	return nil
+}
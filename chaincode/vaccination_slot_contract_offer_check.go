@@ -0,0 +1,102 @@
+package chaincode
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// OfferViolation is a rule of AcceptOffer broken by an offer.
+type OfferViolation struct {
+	Rule    string           `json:"rule"`
+	Code    contracterr.Code `json:"code"`
+	Message string           `json:"message"`
+}
+
+// OfferCheck is returned by CheckOffer.
+// Acceptable is true if there are no violations.
+type OfferCheck struct {
+	OfferUuid  string           `json:"offerUuid"`
+	Acceptable bool             `json:"acceptable"`
+	Violations []OfferViolation `json:"violations"`
+}
+
+// loadTrade reads the offer and its slots for the invoking client as recipient,
+// and returns the rules of the trade policy.
+func (c *VaccinationContract) loadTrade(ctx contractapi.TransactionContextInterface, offerUuid string) (*Trade, []TradeRule, error) {
+	offer, err := getOffer(ctx, offerUuid)
+	if err != nil {
+		return nil, nil, err
+	}
+	recipient, err := getSender(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	senderSlot, err := readVaccinationSlot(ctx, offer.SenderItem)
+	if err != nil {
+		return nil, nil, err
+	}
+	recipientSlot, err := readVaccinationSlot(ctx, offer.RecipientItem)
+	if err != nil {
+		return nil, nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trade := &Trade{
+		Offer:         offer,
+		Recipient:     recipient,
+		SenderSlot:    senderSlot,
+		RecipientSlot: recipientSlot,
+		Now:           now,
+		Config:        cfg,
+	}
+	return trade, c.tradePolicy().Rules(cfg), nil
+}
+
+// CheckOffer evaluates every rule of AcceptOffer for the invoking client without accepting the offer,
+// and returns the broken ones, so clients can tell beforehand why an offer would fail.
+func (c *VaccinationContract) CheckOffer(ctx contractapi.TransactionContextInterface, offerUuid string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "CheckOffer")
+	if err != nil {
+		return "", err
+	}
+
+	trade, rules, err := c.loadTrade(ctx, offerUuid)
+	if err != nil {
+		return "", err
+	}
+
+	result := &OfferCheck{OfferUuid: offerUuid, Violations: make([]OfferViolation, 0)}
+	for _, rule := range rules {
+		err = rule.Check(ctx, trade)
+		if err == nil {
+			continue
+		}
+		// Failures of the ledger aren't violations.
+		if contracterr.CodeOf(err) == contracterr.Internal {
+			return "", err
+		}
+		result.Violations = append(result.Violations, OfferViolation{
+			Rule:    rule.Name,
+			Code:    contracterr.CodeOf(err),
+			Message: contracterr.MessageOf(err),
+		})
+	}
+	result.Acceptable = len(result.Violations) == 0
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
@@ -0,0 +1,219 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// Role of a client in the contract.
+type Role string
+
+const (
+	RoleDoctor  Role = "doctor"
+	RolePatient Role = "patient"
+	RoleAdmin   Role = "admin"
+	// RoleVerifier checks the vaccination of patients who granted a consent, see GrantConsent.
+	RoleVerifier Role = "verifier"
+)
+
+const roleAttribute = "role"
+
+var allRoles = []Role{RoleDoctor, RolePatient, RoleAdmin, RoleVerifier}
+
+// transactionRoles declares the roles allowed to invoke each transaction.
+// Transactions missing from the table can't be invoked by anyone.
+var transactionRoles = map[string][]Role{
+	"ClientAccountId":      allRoles,
+	"GetSlots":             allRoles,
+	"GetSlotsByStatus":     allRoles,
+	"GetSeries":            allRoles,
+	"GetEligibility":       allRoles,
+	"GetVaccinationRecord": allRoles,
+	"IsVaccinated":         {RoleDoctor, RolePatient, RoleVerifier},
+	"GetVerifications":     {RoleDoctor, RolePatient},
+	"BalanceOf":            allRoles,
+	"OwnerOf":              allRoles,
+	"GetApproved":          allRoles,
+	"IsApprovedForAll":     allRoles,
+	"IssueSlot":            {RoleDoctor},
+	"IssueSlotTransient":   {RoleDoctor},
+	"IssueSlotAt":          {RoleDoctor},
+	"IssueSlots":           {RoleDoctor},
+	"BurnToken":            {RoleDoctor},
+	"RevokeSlot":           {RoleDoctor},
+	"MarkNoShow":           {RoleDoctor},
+	"ExpireSlots":          {RoleDoctor},
+	"RescheduleSlot":       {RoleDoctor},
+	"BulkReschedule":       {RoleDoctor},
+	"CancelSlot":           {RolePatient},
+	"ReassignSlot":         {RoleDoctor},
+	"JoinWaitlist":         {RolePatient},
+	"LeaveWaitlist":        {RolePatient},
+	"GetWaitlist":          {RoleDoctor, RoleAdmin},
+	"AssignFromWaitlist":   {RoleDoctor},
+	"MakeOffer":            {RolePatient},
+	"AcceptOffer":          {RolePatient},
+	"CheckOffer":           {RolePatient},
+	"ListOffers":           {RolePatient},
+	"DeleteOffer":          {RolePatient},
+	"GrantConsent":         {RolePatient},
+	"RevokeConsent":        {RolePatient},
+	"PseudonymOf":          {RoleDoctor, RoleAdmin},
+	"GetSlotHistory":       {RoleDoctor, RoleAdmin},
+	"GetOfferHistory":      {RoleDoctor, RoleAdmin},
+	"SetPseudonymSecret":   {RoleAdmin},
+	"GetAccessPolicy":      {RoleDoctor, RoleAdmin},
+	"SetAccessPolicy":      {RoleAdmin},
+	"InitLedger":           {RoleAdmin},
+	"GetConfig":            allRoles,
+	"UpdateConfig":         {RoleAdmin},
+	"MigrateState":         {RoleAdmin},
+	"PurgeRequests":        {RoleAdmin},
+}
+
+// AccessPolicy maps every role to the MSPs whose members may have it.
+//
+// A client has a role if its MSP is listed for the role and its certificate
+// either has no "role" attribute or the attribute equals the role.
+// The admin role always requires the attribute, so a plain member of an admin MSP isn't an admin.
+type AccessPolicy struct {
+	Roles map[Role][]string `json:"roles"`
+}
+
+// defaultAccessPolicy grants the doctor and admin roles to the doctor MSP
+// and the patient role to the patient MSP.
+func defaultAccessPolicy(doctorMSP, patientMSP string) AccessPolicy {
+	return AccessPolicy{
+		Roles: map[Role][]string{
+			RoleDoctor:  {doctorMSP},
+			RolePatient: {patientMSP},
+			RoleAdmin:   {doctorMSP},
+		},
+	}
+}
+
+// validate checks that the policy can't lock out every admin.
+func (policy AccessPolicy) validate() error {
+	if len(policy.Roles[RoleAdmin]) == 0 {
+		return contracterr.New(contracterr.InvalidArgument, "access policy must have at least one admin MSP")
+	}
+	return nil
+}
+
+func getAccessPolicy(ctx contractapi.TransactionContextInterface) (AccessPolicy, error) {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return AccessPolicy{}, err
+	}
+	return config.Policy, nil
+}
+
+// clientRoles returns the roles of the invoking client in a stable order.
+func clientRoles(ctx contractapi.TransactionContextInterface) ([]Role, error) {
+	mspid, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSPID: %w", err)
+	}
+
+	attribute, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s attribute: %w", roleAttribute, err)
+	}
+
+	policy, err := getAccessPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]Role, 0)
+	for role, msps := range policy.Roles {
+		if found && Role(attribute) != role || !found && role == RoleAdmin {
+			continue
+		}
+		for _, msp := range msps {
+			if msp == mspid {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i] < roles[j] })
+	return roles, nil
+}
+
+// authorize checks whether the invoking client has one of the roles declared for tx.
+func (c *VaccinationContract) authorize(ctx contractapi.TransactionContextInterface, tx string) error {
+	required, ok := transactionRoles[tx]
+	if !ok {
+		return contracterr.New(contracterr.Unauthorized, "no roles declared for %s", tx)
+	}
+
+	roles, err := clientRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		for _, r := range required {
+			if role == r {
+				return nil
+			}
+		}
+	}
+	return contracterr.New(contracterr.Unauthorized, "%s requires one of the roles %v", tx, required)
+}
+
+// GetAccessPolicy returns the access policy in effect.
+func (c *VaccinationContract) GetAccessPolicy(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetAccessPolicy")
+	if err != nil {
+		return "", err
+	}
+
+	policy, err := getAccessPolicy(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	policyBytes, err := json.Marshal(&policy)
+	if err != nil {
+		return "", err
+	}
+	return string(policyBytes), nil
+}
+
+// SetAccessPolicy replaces the access policy of the contract configuration,
+// e.g. to let another hospital's MSP issue slots.
+//
+// Policy format: {"roles":{"doctor":["MedicalStationMSP"],"patient":["PatientsMSP"],"admin":["MedicalStationMSP"]}}
+func (c *VaccinationContract) SetAccessPolicy(ctx contractapi.TransactionContextInterface, policyJSON string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "SetAccessPolicy")
+	if err != nil {
+		return err
+	}
+
+	policy := AccessPolicy{}
+	err = json.Unmarshal([]byte(policyJSON), &policy)
+	if err != nil {
+		return contracterr.New(contracterr.InvalidArgument, "failed to unmarshal access policy: %v", err)
+	}
+	err = policy.validate()
+	if err != nil {
+		return err
+	}
+
+	config, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+	config.Policy = policy
+	return config.put(ctx)
+}
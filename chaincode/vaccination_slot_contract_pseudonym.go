@@ -0,0 +1,109 @@
+package chaincode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// Every identity in the public state (slot owners, balance and offer keys)
+// is a pseudonym: the HMAC of the client identity keyed with a channel-level secret.
+// The secret is kept in pseudonymCollection, shared by the medical station and the patients org,
+// so patients can resolve their own pseudonym, and every transaction has to be endorsed by a peer of one of them.
+// The real identities behind the pseudonyms stay in patientCollection of the medical station.
+const (
+	// pseudonymCollection is the private data collection holding the pseudonym secret.
+	// Its members are the medical station and the patients org, see collections_config.json.
+	pseudonymCollection = "pseudonymCollection"
+	secretPrefix        = "secret"
+	secretPseudonym     = "pseudonym"
+)
+
+// transientSecret is the transient key read by SetPseudonymSecret.
+const transientSecret = "secret"
+
+// hmacPseudonym returns the hex encoded HMAC-SHA256 of identity keyed with secret.
+func hmacPseudonym(secret []byte, identity string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(identity))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func pseudonymSecretKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(secretPrefix, []string{secretPseudonym})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+// pseudonym resolves identity to its pseudonym using the channel-level secret.
+func pseudonym(ctx contractapi.TransactionContextInterface, identity string) (string, error) {
+	key, err := pseudonymSecretKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := ctx.GetStub().GetPrivateData(pseudonymCollection, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to GetPrivateData %s: %w", key, err)
+	}
+	if len(secret) == 0 {
+		return "", contracterr.New(contracterr.RuleViolation, "pseudonym secret is not set, an admin has to call SetPseudonymSecret")
+	}
+
+	return hmacPseudonym(secret, identity), nil
+}
+
+// SetPseudonymSecret sets the channel-level secret used to derive pseudonyms.
+// The secret is read from the "secret" key of the transient map.
+//
+// Changing the secret after slots were issued orphans the existing slots,
+// since their owners can't be resolved anymore.
+// Networks that stored the secret in patientCollection have to set the same secret again,
+// since patients can't read that collection.
+func (c *VaccinationContract) SetPseudonymSecret(ctx contractapi.TransactionContextInterface) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "SetPseudonymSecret")
+	if err != nil {
+		return err
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to get transient map: %w", err)
+	}
+
+	secret, ok := transient[transientSecret]
+	if !ok || len(secret) == 0 {
+		return contracterr.New(contracterr.InvalidArgument, "%s must be present in the transient map", transientSecret)
+	}
+
+	key, err := pseudonymSecretKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(pseudonymCollection, key, secret)
+	if err != nil {
+		return fmt.Errorf("failed to PutPrivateData %s: %w", key, err)
+	}
+	return nil
+}
+
+// PseudonymOf maps a real client identity to its pseudonym.
+func (c *VaccinationContract) PseudonymOf(ctx contractapi.TransactionContextInterface, identity string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "PseudonymOf")
+	if err != nil {
+		return "", err
+	}
+
+	return pseudonym(ctx, identity)
+}
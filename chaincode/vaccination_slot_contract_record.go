@@ -0,0 +1,118 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// AdministeredDose is an administered slot in a VaccinationRecord.
+// AdministeredAt and AdministeredBy are taken from the status change to administered.
+type AdministeredDose struct {
+	TokenId        string          `json:"tokenId"`
+	Type           VaccinationType `json:"type"`
+	Date           VaccinationDate `json:"date"`
+	Site           string          `json:"site,omitempty"`
+	Previous       string          `json:"previous,omitempty"`
+	Dose           int             `json:"dose,omitempty"`
+	AdministeredAt time.Time       `json:"administeredAt"`
+	AdministeredBy string          `json:"administeredBy"`
+}
+
+// RecordGroup is the administered doses of a series,
+// or of a vaccine type for the doses issued before series existed.
+type RecordGroup struct {
+	Type         VaccinationType    `json:"type"`
+	SeriesId     string             `json:"seriesId,omitempty"`
+	PlannedDoses int                `json:"plannedDoses,omitempty"`
+	Doses        []AdministeredDose `json:"doses"`
+}
+
+// VaccinationRecord is returned by GetVaccinationRecord.
+type VaccinationRecord struct {
+	Patient string        `json:"patient"`
+	Groups  []RecordGroup `json:"groups"`
+}
+
+// GetVaccinationRecord returns the administered doses of the patient pseudonym in chronological order,
+// grouped by series and vaccine type.
+//
+// Only the patient, the medical station and verifiers with a record consent of the patient can read it, see GrantConsent.
+func (c *VaccinationContract) GetVaccinationRecord(ctx contractapi.TransactionContextInterface, patient string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetVaccinationRecord")
+	if err != nil {
+		return "", err
+	}
+	err = authorizeRead(ctx, patient, ConsentRecord)
+	if err != nil {
+		return "", err
+	}
+
+	slots, err := getSlots(ctx, patient)
+	if err != nil {
+		return "", err
+	}
+	administered := make([]*VaccinationSlot, 0)
+	for _, slot := range slots {
+		if slot.Status == StatusAdministered {
+			administered = append(administered, slot)
+		}
+	}
+	sort.SliceStable(administered, func(i, j int) bool {
+		return time.Time(administered[i].Date).Before(time.Time(administered[j].Date))
+	})
+
+	record := &VaccinationRecord{Patient: patient, Groups: make([]RecordGroup, 0)}
+	index := make(map[string]int)
+	for _, slot := range administered {
+		key := "type|" + string(slot.Type)
+		if len(slot.SeriesId) > 0 {
+			key = "series|" + slot.SeriesId
+		}
+		i, ok := index[key]
+		if !ok {
+			group := RecordGroup{Type: slot.Type, SeriesId: slot.SeriesId, Doses: make([]AdministeredDose, 0)}
+			if len(slot.SeriesId) > 0 {
+				series, err := getSeries(ctx, patient, slot.SeriesId)
+				if err != nil {
+					return "", err
+				}
+				group.Type = series.Product
+				group.PlannedDoses = series.PlannedDoses
+			}
+			i = len(record.Groups)
+			index[key] = i
+			record.Groups = append(record.Groups, group)
+		}
+		record.Groups[i].Doses = append(record.Groups[i].Doses, administeredDose(slot))
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(recordBytes), nil
+}
+
+func administeredDose(slot *VaccinationSlot) AdministeredDose {
+	dose := AdministeredDose{
+		TokenId:  slot.TokenId,
+		Type:     slot.Type,
+		Date:     slot.Date,
+		Site:     slot.Site,
+		Previous: slot.Previous,
+		Dose:     slot.Dose,
+	}
+	for _, change := range slot.StatusHistory {
+		if change.To == StatusAdministered {
+			dose.AdministeredAt = change.Timestamp
+			dose.AdministeredBy = change.Actor
+		}
+	}
+	return dose
+}
@@ -0,0 +1,211 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// Reschedule records a date or site change of a slot.
+type Reschedule struct {
+	OldDate   VaccinationDate `json:"oldDate"`
+	NewDate   VaccinationDate `json:"newDate"`
+	OldSite   string          `json:"oldSite,omitempty"`
+	NewSite   string          `json:"newSite,omitempty"`
+	Reason    string          `json:"reason"`
+	Actor     string          `json:"actor"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// pendingMoves tracks the slots moved earlier in the transaction, because reads don't see its writes.
+type pendingMoves struct {
+	taken        map[string]int
+	dates        map[string]bool
+	doses        map[string]bool
+	administered map[string]bool
+}
+
+func newPendingMoves() *pendingMoves {
+	return &pendingMoves{
+		taken:        make(map[string]int),
+		dates:        make(map[string]bool),
+		doses:        make(map[string]bool),
+		administered: make(map[string]bool),
+	}
+}
+
+func (pending *pendingMoves) add(slot *VaccinationSlot) {
+	pending.taken[slot.Site+"|"+slot.Date.String()]++
+	pending.dates[slot.Owner+"|"+slot.Date.String()] = true
+	if len(slot.SeriesId) > 0 {
+		pending.doses[slot.SeriesId+"|"+fmt.Sprint(slot.Dose)] = true
+	}
+}
+
+// RescheduleSlot moves a live or pooled slot to newDate, e.g. because its site closes.
+//
+// The owner must not have another slot on newDate, newDate must be within the dose interval of the previous dose
+// and the site of the slot must have a free place on newDate.
+// Open offers of the owner referencing the slot are deleted,
+// the old date and the reason are kept in the RescheduleHistory of the slot.
+// Emits a SlotRescheduled event.
+//
+// Date format must be 2006-01-02.
+func (c *VaccinationContract) RescheduleSlot(ctx contractapi.TransactionContextInterface, slotUuid, newDate, reason string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "RescheduleSlot")
+	if err != nil {
+		return err
+	}
+
+	if len(reason) == 0 {
+		return contracterr.New(contracterr.InvalidArgument, "reason must be set")
+	}
+
+	date, err := parseDate(newDate)
+	if err != nil {
+		return err
+	}
+
+	slot, err := readVaccinationSlot(ctx, slotUuid)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := newPendingMoves()
+	err = c.checkMove(ctx, cfg, slot, slot.Site, date, now, pending)
+	if err != nil {
+		return err
+	}
+	record, err := c.moveSlot(ctx, slot, slot.Site, date, reason, now, pending)
+	if err != nil {
+		return err
+	}
+
+	return emitSlotRescheduled(ctx, slot, record)
+}
+
+// checkMove validates moving the slot to site on date.
+func (c *VaccinationContract) checkMove(ctx contractapi.TransactionContextInterface, cfg ContractConfig, slot *VaccinationSlot, site string, date VaccinationDate, now time.Time, pending *pendingMoves) error {
+	if !slot.IsLive() && slot.Status != StatusCancelled {
+		return contracterr.New(contracterr.RuleViolation, "slot %s is %s", slot.TokenId, slot.Status)
+	}
+	sameDate := slot.Date.String() == date.String()
+	if sameDate && slot.Site == site {
+		return contracterr.New(contracterr.Conflict, "slot %s is already on %s", slot.TokenId, date)
+	}
+	if time.Time(date).Before(now) {
+		return contracterr.New(contracterr.Expired, "date %s is in the past", date)
+	}
+
+	if slot.IsLive() && !sameDate {
+		occupied := pending.dates[slot.Owner+"|"+date.String()]
+		if !occupied {
+			var err error
+			occupied, err = dateOccupied(ctx, slot.Owner, date)
+			if err != nil {
+				return err
+			}
+		}
+		if occupied {
+			return contracterr.New(contracterr.Conflict, "slot occupied on %s", date)
+		}
+	}
+
+	err := checkInterval(ctx, cfg, slot.Previous, date)
+	if err != nil {
+		return err
+	}
+	err = checkCompatibility(ctx, cfg, slot.Previous, slot.Type)
+	if err != nil {
+		return err
+	}
+
+	ok, err := hasCapacity(ctx, cfg, site, date, pending.taken[site+"|"+date.String()])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return contracterr.New(contracterr.Conflict, "site %s is full on %s", site, date)
+	}
+	return nil
+}
+
+// moveSlot moves the slot to site on date without validation, see checkMove.
+// Open offers of the owner referencing the slot are deleted.
+func (c *VaccinationContract) moveSlot(ctx contractapi.TransactionContextInterface, slot *VaccinationSlot, site string, date VaccinationDate, reason string, now time.Time, pending *pendingMoves) (Reschedule, error) {
+	actor, err := getSender(ctx)
+	if err != nil {
+		return Reschedule{}, err
+	}
+
+	err = slot.delSiteDate(ctx)
+	if err != nil {
+		return Reschedule{}, err
+	}
+
+	record := Reschedule{
+		OldDate:   slot.Date,
+		NewDate:   date,
+		Reason:    reason,
+		Actor:     actor,
+		Timestamp: now,
+	}
+	if site != slot.Site {
+		record.OldSite = slot.Site
+		record.NewSite = site
+	}
+	slot.RescheduleHistory = append(slot.RescheduleHistory, record)
+	slot.Site = site
+	slot.Date = date
+
+	err = slot.put(ctx)
+	if err != nil {
+		return Reschedule{}, err
+	}
+	err = slot.putSiteDate(ctx)
+	if err != nil {
+		return Reschedule{}, err
+	}
+	pending.add(slot)
+
+	err = delSlotOffers(ctx, slot)
+	if err != nil {
+		return Reschedule{}, err
+	}
+	return record, nil
+}
+
+func emitSlotRescheduled(ctx contractapi.TransactionContextInterface, slot *VaccinationSlot, record Reschedule) error {
+	event := &SlotRescheduled{
+		TokenId: slot.TokenId,
+		Owner:   slot.Owner,
+		OldDate: record.OldDate,
+		NewDate: record.NewDate,
+		Reason:  record.Reason,
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SlotRescheduled: %w", err)
+	}
+
+	err = ctx.GetStub().SetEvent("SlotRescheduled", eventBytes)
+	if err != nil {
+		return fmt.Errorf("failed to SetEvent SlotRescheduled: %w", err)
+	}
+	return nil
+}
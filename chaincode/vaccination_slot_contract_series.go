@@ -0,0 +1,285 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const seriesPrefix = "series"
+
+// Series states returned by GetSeries.
+const (
+	SeriesInProgress = "in-progress"
+	SeriesComplete   = "complete"
+	SeriesClosed     = "closed"
+)
+
+// Series is the planned doses of a vaccine for a patient, stored as series.patient.seriesId.
+//
+// A series is started by a slot issued without a previous dose, its id is the token id of that slot.
+// The slots issued with a previous dose of the series continue it with the next dose number.
+// A series is closed when its first slot is cancelled before any dose is administered.
+type Series struct {
+	Id           string          `json:"id"`
+	Patient      string          `json:"patient"`
+	Product      VaccinationType `json:"product"`
+	PlannedDoses int             `json:"plannedDoses"`
+	Closed       bool            `json:"closed,omitempty"`
+	Version      int             `json:"schemaVersion"`
+}
+
+// SeriesDose is a slot of a series returned by GetSeries.
+type SeriesDose struct {
+	Dose    int             `json:"dose"`
+	TokenId string          `json:"tokenId"`
+	Type    VaccinationType `json:"type"`
+	Date    VaccinationDate `json:"date"`
+	Status  SlotStatus      `json:"status"`
+}
+
+// SeriesStatus is a series of a patient with its slots ordered by dose number, returned by GetSeries.
+type SeriesStatus struct {
+	Series
+	Administered int          `json:"administered"`
+	Status       string       `json:"status"`
+	Doses        []SeriesDose `json:"doses"`
+}
+
+func seriesKey(ctx contractapi.TransactionContextInterface, patient, seriesId string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(seriesPrefix, []string{patient, seriesId})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+func (series *Series) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := seriesKey(ctx, series.Patient, series.Id)
+	if err != nil {
+		return err
+	}
+
+	series.Version = SchemaVersion
+	seriesBytes, err := json.Marshal(series)
+	if err != nil {
+		return fmt.Errorf("failed to marshal series: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(key, seriesBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+// getSeries reads the series seriesId of patient.
+func getSeries(ctx contractapi.TransactionContextInterface, patient, seriesId string) (*Series, error) {
+	key, err := seriesKey(ctx, patient, seriesId)
+	if err != nil {
+		return nil, err
+	}
+	seriesBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state %s: %w", key, err)
+	}
+	if len(seriesBytes) == 0 {
+		return nil, contracterr.New(contracterr.NotFound, "series %s of %s doesn't exist", seriesId, patient)
+	}
+
+	series := &Series{}
+	err = decodeRecord(seriesPrefix, seriesBytes, series)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return series, nil
+}
+
+// listSeries returns every series of patient.
+func listSeries(ctx contractapi.TransactionContextInterface, patient string) ([]*Series, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(seriesPrefix, []string{patient})
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", seriesPrefix, err)
+	}
+	defer iterator.Close()
+
+	series := make([]*Series, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failure while iterating: %w", err)
+		}
+		s := &Series{}
+		err = decodeRecord(seriesPrefix, kv.Value, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", kv.Key, err)
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// nextDose returns the series and dose number of slot tokenId of owner issued after previous.
+//
+// Without a previous dose the slot starts a new series.
+// A previous dose issued before series existed leaves the slot outside of any series.
+// Otherwise previous must be the administered last issued dose of an open series of owner that has doses left.
+func nextDose(ctx contractapi.TransactionContextInterface, owner, previous, tokenId string, pending *pendingMoves) (string, int, error) {
+	if len(previous) == 0 {
+		return tokenId, 1, nil
+	}
+	prev, err := readVaccinationSlot(ctx, previous)
+	if err != nil {
+		return "", 0, contracterr.Annotate(err, "previous slot present but can't be read")
+	}
+	if prev.Status != StatusAdministered && !pending.administered[previous] {
+		return "", 0, contracterr.New(contracterr.RuleViolation, "previous %s is not administered", previous)
+	}
+	if len(prev.SeriesId) == 0 {
+		return "", 0, nil
+	}
+
+	series, err := getSeries(ctx, owner, prev.SeriesId)
+	if contracterr.CodeOf(err) == contracterr.NotFound {
+		return "", 0, contracterr.New(contracterr.RuleViolation, "previous %s is not in a series of %s", previous, owner)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if series.Closed {
+		return "", 0, contracterr.New(contracterr.RuleViolation, "series %s is closed", series.Id)
+	}
+	dose := prev.Dose + 1
+	if dose > series.PlannedDoses {
+		return "", 0, contracterr.New(contracterr.RuleViolation, "series %s is complete with %d doses", series.Id, series.PlannedDoses)
+	}
+
+	issued := pending.doses[series.Id+"|"+fmt.Sprint(dose)]
+	if !issued {
+		slots, err := getSlots(ctx, owner)
+		if err != nil {
+			return "", 0, err
+		}
+		for _, slot := range slots {
+			if slot.SeriesId == series.Id && slot.Dose >= dose {
+				issued = true
+			}
+		}
+	}
+	if issued {
+		return "", 0, contracterr.New(contracterr.Conflict, "dose %d of series %s is already issued", dose, series.Id)
+	}
+	return series.Id, dose, nil
+}
+
+// startSeries stores the series started by vs if it's the first dose.
+func startSeries(ctx contractapi.TransactionContextInterface, cfg ContractConfig, vs *VaccinationSlot) error {
+	if vs.Dose != 1 {
+		return nil
+	}
+	series := &Series{
+		Id:           vs.SeriesId,
+		Patient:      vs.Owner,
+		Product:      vs.Type,
+		PlannedDoses: cfg.plannedDoses(vs.Type),
+	}
+	return series.put(ctx)
+}
+
+// closeSeries closes the series started by the cancelled slot vs, none of its doses is administered yet.
+// The later doses of a series can be issued again after the last administered dose.
+func closeSeries(ctx contractapi.TransactionContextInterface, vs *VaccinationSlot) error {
+	if len(vs.SeriesId) == 0 || vs.Dose != 1 {
+		return nil
+	}
+	series, err := getSeries(ctx, vs.Owner, vs.SeriesId)
+	if err != nil {
+		return err
+	}
+	series.Closed = true
+	return series.put(ctx)
+}
+
+// GetSeries returns the dose series of the patient pseudonym with their slots in dose order.
+//
+// The series are ordered by the date of their first slot.
+// A series is complete when every planned dose is administered, closed when its first slot is cancelled.
+// Only the patient, the medical station and verifiers with a record consent of the patient can read them.
+func (c *VaccinationContract) GetSeries(ctx contractapi.TransactionContextInterface, patient string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetSeries")
+	if err != nil {
+		return "", err
+	}
+	err = authorizeRead(ctx, patient, ConsentRecord)
+	if err != nil {
+		return "", err
+	}
+
+	series, err := listSeries(ctx, patient)
+	if err != nil {
+		return "", err
+	}
+	slots, err := getSlots(ctx, patient)
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]*SeriesStatus, 0, len(series))
+	index := make(map[string]*SeriesStatus)
+	for _, s := range series {
+		status := &SeriesStatus{Series: *s, Doses: make([]SeriesDose, 0)}
+		index[s.Id] = status
+		result = append(result, status)
+	}
+	for _, slot := range slots {
+		status, ok := index[slot.SeriesId]
+		if !ok {
+			continue
+		}
+		status.Doses = append(status.Doses, SeriesDose{
+			Dose:    slot.Dose,
+			TokenId: slot.TokenId,
+			Type:    slot.Type,
+			Date:    slot.Date,
+			Status:  slot.Status,
+		})
+		if slot.Status == StatusAdministered {
+			status.Administered++
+		}
+	}
+
+	for _, status := range result {
+		sort.Slice(status.Doses, func(i, j int) bool {
+			return status.Doses[i].Dose < status.Doses[j].Dose
+		})
+		status.Status = SeriesInProgress
+		if status.Administered >= status.PlannedDoses {
+			status.Status = SeriesComplete
+		} else if status.Closed {
+			status.Status = SeriesClosed
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return seriesStart(result[i]).Before(seriesStart(result[j]))
+	})
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+// seriesStart is the date of the first slot of the series, the zero time if it has none.
+func seriesStart(status *SeriesStatus) time.Time {
+	if len(status.Doses) == 0 {
+		return time.Time{}
+	}
+	return time.Time(status.Doses[0].Date)
+}
@@ -0,0 +1,108 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// siteDatePrefix indexes the slots by site and date as sitedate.site.date.tokenId.
+const siteDatePrefix = "sitedate"
+
+// SiteConfig holds the settings of a vaccination site.
+type SiteConfig struct {
+	// Slots per day, 0 means unlimited.
+	Capacity int `json:"capacity"`
+}
+
+// capacity returns the slots per day of site, 0 means unlimited.
+func (cfg ContractConfig) capacity(site string) int {
+	return cfg.Sites[site].Capacity
+}
+
+// holdsCapacity reports whether the slot takes a place at its site on its date.
+// Cancelled slots keep their place until they expire or are reassigned.
+func (slot *VaccinationSlot) holdsCapacity() bool {
+	return slot.occupies() || slot.Status == StatusCancelled
+}
+
+func (slot *VaccinationSlot) siteDateKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(siteDatePrefix, []string{slot.Site, slot.Date.String(), slot.TokenId})
+	if err != nil {
+		return "", fmt.Errorf("failed to CreateCompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+func (slot *VaccinationSlot) putSiteDate(ctx contractapi.TransactionContextInterface) error {
+	key, err := slot.siteDateKey(ctx)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, []byte(slot.TokenId))
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+func (slot *VaccinationSlot) delSiteDate(ctx contractapi.TransactionContextInterface) error {
+	key, err := slot.siteDateKey(ctx)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to DelState %s: %w", key, err)
+	}
+	return nil
+}
+
+// siteLoad counts the slots holding capacity at site on date.
+func siteLoad(ctx contractapi.TransactionContextInterface, site string, date VaccinationDate) (int, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(siteDatePrefix, []string{site, date.String()})
+	if err != nil {
+		return 0, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", siteDatePrefix, err)
+	}
+	defer iterator.Close()
+
+	load := 0
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return 0, fmt.Errorf("failure while iterating: %w", err)
+		}
+		slot, err := readVaccinationSlot(ctx, string(kv.Value))
+		if err != nil {
+			return 0, err
+		}
+		if slot.holdsCapacity() {
+			load++
+		}
+	}
+	return load, nil
+}
+
+// hasCapacity reports whether site has a free place on date.
+// Taken is the number of places taken earlier in the transaction, because reads don't see its writes.
+func hasCapacity(ctx contractapi.TransactionContextInterface, cfg ContractConfig, site string, date VaccinationDate, taken int) (bool, error) {
+	capacity := cfg.capacity(site)
+	if capacity == 0 {
+		return true, nil
+	}
+	load, err := siteLoad(ctx, site, date)
+	if err != nil {
+		return false, err
+	}
+	return load+taken < capacity, nil
+}
+
+// indexSlotSite adds a migrated slot record to the site and date index.
+func indexSlotSite(ctx contractapi.TransactionContextInterface, record []byte) error {
+	slot := &VaccinationSlot{}
+	err := decodeRecord(vsPrefix, record, slot)
+	if err != nil {
+		return err
+	}
+	return slot.putSiteDate(ctx)
+}
@@ -3,8 +3,12 @@ package chaincode
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -13,22 +17,39 @@ import (
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	patient1 = "x509::CN=Patient1,OU=client::CN=Patients CA"
 	patient2 = "x509::CN=Patient2,OU=client::CN=Patients CA"
 	patient3 = "x509::CN=Patient3,OU=client::CN=Patients CA"
+	doctor1  = "x509::CN=Doctor1,OU=client::CN=MedicalStation CA"
 	slot1    = "slot1"
 	slot2    = "slot2"
 	slot3    = "slot3"
 	slot4    = "slot4"
+	slot5    = "slot5"
+	slot6    = "slot6"
 	offer1   = "offer1"
 	offer2   = "offer2"
 )
 
+const pseudonymTestSecret = "secret"
+
+var (
+	pseudonym1 = pseudonymOf(patient1)
+	pseudonym2 = pseudonymOf(patient2)
+	pseudonym3 = pseudonymOf(patient3)
+)
+
+func pseudonymOf(identity string) string {
+	return hmacPseudonym([]byte(pseudonymTestSecret), identity)
+}
+
 const (
 	getStub                       = "GetStub"
 	createCompositeKey            = "CreateCompositeKey"
@@ -40,6 +61,14 @@ const (
 	getMSPID                      = "GetMSPID"
 	getID                         = "GetID"
 	delState                      = "DelState"
+	getTransient                  = "GetTransient"
+	putPrivateData                = "PutPrivateData"
+	getPrivateData                = "GetPrivateData"
+	getAttributeValue             = "GetAttributeValue"
+	splitCompositeKey             = "SplitCompositeKey"
+	getTxTimestamp                = "GetTxTimestamp"
+	getHistoryForKey              = "GetHistoryForKey"
+	getTxID                       = "GetTxID"
 )
 
 type MockStub struct {
@@ -77,6 +106,51 @@ func (ms *MockStub) CreateCompositeKey(objectType string, attributes []string) (
 	return args.Get(0).(string), args.Error(1)
 }
 
+func (ms *MockStub) GetTransient() (map[string][]byte, error) {
+	args := ms.Called()
+	return args.Get(0).(map[string][]byte), args.Error(1)
+}
+
+func (ms *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	args := ms.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (ms *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	args := ms.Called(collection, key, value)
+	return args.Error(0)
+}
+
+func (ms *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	args := ms.Called(compositeKey)
+	return args.String(0), args.Get(1).([]string), args.Error(2)
+}
+
+func (ms *MockStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	args := ms.Called()
+	return args.Get(0).(*timestamppb.Timestamp), args.Error(1)
+}
+
+func (ms *MockStub) GetTxID() string {
+	args := ms.Called()
+	return args.String(0)
+}
+
+func (ms *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	args := ms.Called(key)
+	return args.Get(0).(*MockHistoryIterator), args.Error(1)
+}
+
+// mockTxTime registers the timestamp of the transaction.
+func mockTxTime(ms *MockStub, date string) time.Time {
+	value, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ms.On(getTxTimestamp).Return(timestamppb.New(value), nil)
+	return value
+}
+
 type MockClientIdentity struct {
 	cid.ClientIdentity
 	mock.Mock
@@ -92,6 +166,29 @@ func (mci *MockClientIdentity) GetMSPID() (string, error) {
 	return args.Get(0).(string), args.Error(1)
 }
 
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+// mockRole registers a client of mspid without a role attribute under the default configuration.
+func mockRole(ms *MockStub, mci *MockClientIdentity, mspid string) {
+	key := strings.Join([]string{configPrefix, configContract}, ".")
+	ms.On(createCompositeKey, configPrefix, []string{configContract}).Return(key, nil)
+	ms.On(getState, key).Return([]byte{}, nil)
+	mci.On(getMSPID).Return(mspid, nil)
+	mci.On(getAttributeValue, roleAttribute).Return("", false, nil)
+}
+
+// mockAdmin registers a client of mspid with the admin role attribute under the default configuration.
+func mockAdmin(ms *MockStub, mci *MockClientIdentity, mspid string) {
+	key := strings.Join([]string{configPrefix, configContract}, ".")
+	ms.On(createCompositeKey, configPrefix, []string{configContract}).Return(key, nil)
+	ms.On(getState, key).Return([]byte{}, nil)
+	mci.On(getMSPID).Return(mspid, nil)
+	mci.On(getAttributeValue, roleAttribute).Return(string(RoleAdmin), true, nil)
+}
+
 type MockContext struct {
 	contractapi.TransactionContextInterface
 	mock.Mock
@@ -126,6 +223,52 @@ func (it *MockIterator) Next() (*queryresult.KV, error) {
 	return nil, nil
 }
 
+// mockSiteDate registers the writes of the site and date index under siteDatePrefix.
+func mockSiteDate(ms *MockStub) {
+	ms.On(createCompositeKey, siteDatePrefix, mock.Anything).Return(siteDatePrefix, nil)
+	ms.On(putState, siteDatePrefix, mock.AnythingOfType("[]uint8")).Return(nil)
+	ms.On(delState, siteDatePrefix).Return(nil)
+}
+
+// mockSeries registers the writes of the series started by issued slots under seriesPrefix.
+func mockSeries(ms *MockStub) {
+	ms.On(createCompositeKey, seriesPrefix, mock.Anything).Return(seriesPrefix, nil)
+	ms.On(putState, seriesPrefix, mock.AnythingOfType("[]uint8")).Return(nil)
+}
+
+// mockPseudonymSecret registers the pseudonym secret in the private collection.
+func mockPseudonymSecret(ms *MockStub) {
+	key := strings.Join([]string{secretPrefix, secretPseudonym}, ".")
+	ms.On(createCompositeKey, secretPrefix, []string{secretPseudonym}).Return(key, nil)
+	ms.On(getPrivateData, pseudonymCollection, key).Return([]byte(pseudonymTestSecret), nil)
+}
+
+func (it *MockIterator) Close() error {
+	return nil
+}
+
+type MockHistoryIterator struct {
+	shim.HistoryQueryIteratorInterface
+	modifications []queryresult.KeyModification
+}
+
+func (it *MockHistoryIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *MockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if it.HasNext() {
+		value := it.modifications[0]
+		it.modifications = it.modifications[1:]
+		return &value, nil
+	}
+	return nil, nil
+}
+
+func (it *MockHistoryIterator) Close() error {
+	return nil
+}
+
 type MockTokenIdGenerator struct {
 	Ids []string
 }
@@ -148,10 +291,12 @@ func TestBalanceOf(t *testing.T) {
 	ctx := setupTestBalanceOf()
 	c := &VaccinationContract{}
 
-	balance := c.BalanceOf(ctx, patient1)
+	balance, err := c.BalanceOf(ctx, patient1)
+	assert.Nil(t, err)
 	assert.Equal(t, 0, balance)
 
-	balance = c.BalanceOf(ctx, patient2)
+	balance, err = c.BalanceOf(ctx, patient2)
+	assert.Nil(t, err)
 	assert.Equal(t, 2, balance)
 }
 
@@ -179,6 +324,7 @@ func setupTestBalanceOf() *MockContext {
 	ms.On(getStateByPartialCompositeKey, vsPrefix, []string{}).Return(emptyIterator, nil)
 
 	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultPatientMSP)
 
 	mc := &MockContext{}
 	mc.On(getStub).Return(ms)
@@ -188,6 +334,36 @@ func setupTestBalanceOf() *MockContext {
 
 //</editor-fold>
 
+//<editor-fold desc="Test GetSlots">
+func TestGetSlots(t *testing.T) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return((*MockIterator)(nil), errors.New("ledger unavailable"))
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultPatientMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(patient1)), nil)
+
+	ctx := &MockContext{}
+	ctx.On(getStub).Return(ms)
+	ctx.On(getClientIdentity).Return(mci)
+	c := &VaccinationContract{}
+
+	_, err := c.GetSlots(ctx, pseudonym1)
+	assert.ErrorIs(t, err, contracterr.Internal)
+
+	_, err = c.GetSlotsByStatus(ctx, pseudonym1, "unknown")
+	assert.ErrorIs(t, err, contracterr.InvalidArgument)
+
+	for _, status := range []SlotStatus{StatusRevoked, StatusExpired, StatusNoShow} {
+		_, err = c.GetSlotsByStatus(ctx, pseudonym1, string(status))
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+	}
+}
+
+//</editor-fold>
+
 //<editor-fold desc="Test OwnerOf">
 func TestOwnerOf(t *testing.T) {
 	ctx := setupTestOwnerOf()
@@ -195,6 +371,10 @@ func TestOwnerOf(t *testing.T) {
 
 	owner, _ := c.OwnerOf(ctx, "slot1")
 	assert.Equal(t, patient1, owner)
+
+	_, err := c.OwnerOf(ctx, slot2)
+	assert.ErrorIs(t, err, contracterr.NotFound)
+	assert.JSONEq(t, `{"code":"NOT_FOUND","message":"slot slot2 doesn't exist"}`, err.Error())
 }
 
 func setupTestOwnerOf() *MockContext {
@@ -212,8 +392,196 @@ func setupTestOwnerOf() *MockContext {
 
 	ms.On(createCompositeKey, vsPrefix, []string{"slot1"}).Return(vsPrefix+".slot1", nil)
 	ms.On(getState, vsPrefix+".slot1").Return(vsb, nil)
+	ms.On(createCompositeKey, vsPrefix, []string{slot2}).Return(vsPrefix+".slot2", nil)
+	ms.On(getState, vsPrefix+".slot2").Return([]byte{}, nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultPatientMSP)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test Pseudonym">
+func TestPseudonymOf(t *testing.T) {
+	t.Run("Correct", func(t *testing.T) {
+		ctx := setupTestPseudonym("MedicalStationMSP")
+		c := &VaccinationContract{}
+		pseudonym, err := c.PseudonymOf(ctx, patient1)
+		assert.Nil(t, err)
+		assert.Equal(t, pseudonym1, pseudonym)
+		assert.NotEqual(t, pseudonym1, pseudonym2)
+	})
+	t.Run("Wrong MSPID", func(t *testing.T) {
+		ctx := setupTestPseudonym("PatientsMSP")
+		c := &VaccinationContract{}
+		_, err := c.PseudonymOf(ctx, patient1)
+		assert.Error(t, err)
+	})
+	t.Run("ClientAccountId", func(t *testing.T) {
+		ctx := setupTestPseudonym("PatientsMSP")
+		c := &VaccinationContract{}
+		id, err := c.ClientAccountId(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, pseudonym1, id)
+	})
+	t.Run("Non-member MSP", func(t *testing.T) {
+		ctx := setupTestPseudonym(defaultPatientMSP)
+		_, err := ctx.GetStub().GetPrivateData(patientCollection, strings.Join([]string{patientPrefix, pseudonym1}, "."))
+		assert.Error(t, err)
+
+		c := &VaccinationContract{}
+		id, err := c.ClientAccountId(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, pseudonym1, id)
+	})
+	t.Run("Secret not set", func(t *testing.T) {
+		ms := &MockStub{}
+		key := strings.Join([]string{secretPrefix, secretPseudonym}, ".")
+		ms.On(createCompositeKey, secretPrefix, []string{secretPseudonym}).Return(key, nil)
+		ms.On(getPrivateData, pseudonymCollection, key).Return([]byte{}, nil)
+		mci := &MockClientIdentity{}
+		mockRole(ms, mci, defaultDoctorMSP)
+		ctx := &MockContext{}
+		ctx.On(getStub).Return(ms)
+		ctx.On(getClientIdentity).Return(mci)
+
+		c := &VaccinationContract{}
+		_, err := c.PseudonymOf(ctx, patient1)
+		assert.True(t, errors.Is(err, contracterr.RuleViolation))
+		assert.Contains(t, err.Error(), "SetPseudonymSecret")
+	})
+}
+
+func setupTestPseudonym(mspid string) *MockContext {
+	ms := &MockStub{}
+	mockCollectionACL(ms, mspid)
+	mockPseudonymSecret(ms)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, mspid)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(patient1)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc
+}
+
+//</editor-fold>
+
+// mockCollectionACL makes GetPrivateData fail for the collections of collections_config.json
+// that only their members can read and mspid isn't a member of, like Fabric does.
+// It has to be called before the private data is mocked, since the first registration wins.
+func mockCollectionACL(ms *MockStub, mspid string) {
+	configBytes, err := os.ReadFile("../collections_config.json")
+	if err != nil {
+		log.Fatalf("failed to read collections_config.json: %v", err)
+	}
+	var collections []struct {
+		Name           string `json:"name"`
+		Policy         string `json:"policy"`
+		MemberOnlyRead bool   `json:"memberOnlyRead"`
+	}
+	if err := json.Unmarshal(configBytes, &collections); err != nil {
+		log.Fatalf("failed to parse collections_config.json: %v", err)
+	}
+	for _, collection := range collections {
+		if collection.MemberOnlyRead && !strings.Contains(collection.Policy, "'"+mspid+".member'") {
+			ms.On(getPrivateData, collection.Name, mock.Anything).Return([]byte{}, fmt.Errorf("tx creator does not have read access permission on privatedata in chaincodeName:vaccination collectionName: %s", collection.Name))
+		}
+	}
+}
+
+//<editor-fold desc="Test Authorize">
+func TestAuthorize(t *testing.T) {
+	t.Run("Every transaction declares roles", func(t *testing.T) {
+		inherited := reflect.TypeOf(&contractapi.Contract{})
+		contract := reflect.TypeOf(&VaccinationContract{})
+		for i := 0; i < contract.NumMethod(); i++ {
+			name := contract.Method(i).Name
+			if _, ok := inherited.MethodByName(name); ok {
+				continue
+			}
+			assert.Contains(t, transactionRoles, name)
+		}
+	})
+	t.Run("Default policy", func(t *testing.T) {
+		ctx := setupTestAuthorize(defaultDoctorMSP, "", nil)
+		c := &VaccinationContract{}
+		assert.Nil(t, c.authorize(ctx, "IssueSlot"))
+		assert.True(t, errors.Is(c.authorize(ctx, "MakeOffer"), contracterr.Unauthorized))
+	})
+	t.Run("Admin without attribute", func(t *testing.T) {
+		ctx := setupTestAuthorize(defaultDoctorMSP, "", nil)
+		c := &VaccinationContract{}
+		for _, tx := range []string{"SetAccessPolicy", "UpdateConfig", "SetPseudonymSecret", "MigrateState"} {
+			assert.True(t, errors.Is(c.authorize(ctx, tx), contracterr.Unauthorized), tx)
+		}
+	})
+	t.Run("Admin attribute", func(t *testing.T) {
+		ctx := setupTestAuthorize(defaultDoctorMSP, string(RoleAdmin), nil)
+		c := &VaccinationContract{}
+		assert.Nil(t, c.authorize(ctx, "SetAccessPolicy"))
+		assert.True(t, errors.Is(c.authorize(ctx, "IssueSlot"), contracterr.Unauthorized))
+	})
+	t.Run("Role attribute", func(t *testing.T) {
+		ctx := setupTestAuthorize(defaultDoctorMSP, string(RoleDoctor), nil)
+		c := &VaccinationContract{}
+		assert.Nil(t, c.authorize(ctx, "IssueSlot"))
+		assert.True(t, errors.Is(c.authorize(ctx, "SetAccessPolicy"), contracterr.Unauthorized))
+	})
+	t.Run("Role attribute of other MSP", func(t *testing.T) {
+		ctx := setupTestAuthorize(defaultPatientMSP, string(RoleDoctor), nil)
+		c := &VaccinationContract{}
+		assert.True(t, errors.Is(c.authorize(ctx, "IssueSlot"), contracterr.Unauthorized))
+	})
+	t.Run("Policy from state", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Policy.Roles[RoleDoctor] = append(cfg.Policy.Roles[RoleDoctor], "SecondHospitalMSP")
+		ctx := setupTestAuthorize("SecondHospitalMSP", "", &cfg)
+		c := &VaccinationContract{}
+		assert.Nil(t, c.authorize(ctx, "IssueSlot"))
+		assert.True(t, errors.Is(c.authorize(ctx, "SetAccessPolicy"), contracterr.Unauthorized))
+	})
+	t.Run("Verifier", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Policy.Roles[RoleVerifier] = []string{"EmployerMSP"}
+		ctx := setupTestAuthorize("EmployerMSP", string(RoleVerifier), &cfg)
+		c := &VaccinationContract{}
+		assert.Nil(t, c.authorize(ctx, "IsVaccinated"))
+		assert.Nil(t, c.authorize(ctx, "GetVaccinationRecord"))
+		for _, tx := range []string{"GetVerifications", "IssueSlot", "MakeOffer"} {
+			assert.True(t, errors.Is(c.authorize(ctx, tx), contracterr.Unauthorized), tx)
+		}
+	})
+	t.Run("Undeclared transaction", func(t *testing.T) {
+		ctx := setupTestAuthorize(defaultDoctorMSP, "", nil)
+		c := &VaccinationContract{}
+		assert.True(t, errors.Is(c.authorize(ctx, "Undeclared"), contracterr.Unauthorized))
+	})
+}
+
+func setupTestAuthorize(mspid string, role string, cfg *ContractConfig) *MockContext {
+	ms := &MockStub{}
+
+	configBytes := []byte{}
+	if cfg != nil {
+		configBytes, _ = json.Marshal(cfg)
+	}
+	key := strings.Join([]string{configPrefix, configContract}, ".")
+	ms.On(createCompositeKey, configPrefix, []string{configContract}).Return(key, nil)
+	ms.On(getState, key).Return(configBytes, nil)
 
 	mci := &MockClientIdentity{}
+	mci.On(getMSPID).Return(mspid, nil)
+	mci.On(getAttributeValue, roleAttribute).Return(role, len(role) > 0, nil)
 
 	mc := &MockContext{}
 	mc.On(getStub).Return(ms)
@@ -224,6 +592,159 @@ func setupTestOwnerOf() *MockContext {
 
 //</editor-fold>
 
+//<editor-fold desc="Test Config">
+func TestInitLedger(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, nil)
+		c := &VaccinationContract{}
+		err := c.InitLedger(ctx, "")
+		assert.Nil(t, err)
+		cfgBytes, _ := json.Marshal(DefaultConfig())
+		ms.AssertCalled(t, putState, strings.Join([]string{configPrefix, configContract}, "."), cfgBytes)
+	})
+	t.Run("Custom", func(t *testing.T) {
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, nil)
+		c := &VaccinationContract{}
+		err := c.InitLedger(ctx, `{"network":{"doctor_mspid":"HospitalMSP","patient_mspid":"PatientsMSP"}}`)
+		assert.Nil(t, err)
+		cfg := ContractConfig{}
+		cfgBytes := ms.Calls[len(ms.Calls)-1].Arguments.Get(1).([]byte)
+		assert.Nil(t, json.Unmarshal(cfgBytes, &cfg))
+		assert.Equal(t, []string{"HospitalMSP"}, cfg.Policy.Roles[RoleDoctor])
+		assert.Equal(t, []string{"HospitalMSP"}, cfg.Policy.Roles[RoleAdmin])
+	})
+	t.Run("Missing MSP", func(t *testing.T) {
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, nil)
+		c := &VaccinationContract{}
+		err := c.InitLedger(ctx, `{"network":{"doctor_mspid":"HospitalMSP"}}`)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Already initialized", func(t *testing.T) {
+		cfg := DefaultConfig()
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.InitLedger(ctx, "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Patient", func(t *testing.T) {
+		ctx, ms := setupTestConfig(defaultPatientMSP, RoleAdmin, nil)
+		c := &VaccinationContract{}
+		err := c.InitLedger(ctx, "")
+		assert.True(t, errors.Is(err, contracterr.Unauthorized))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Doctor without admin attribute", func(t *testing.T) {
+		ctx, ms := setupTestConfig(defaultDoctorMSP, "", nil)
+		c := &VaccinationContract{}
+		err := c.InitLedger(ctx, "")
+		assert.True(t, errors.Is(err, contracterr.Unauthorized))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUpdateConfig(t *testing.T) {
+	t.Run("Correct", func(t *testing.T) {
+		cfg := DefaultConfig()
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.UpdateConfig(ctx, `{"network":{"doctor_mspid":"HospitalMSP","patient_mspid":"PatientsMSP"},"policy":{"roles":{"admin":["HospitalMSP"]}}}`)
+		assert.Nil(t, err)
+		ms.AssertCalled(t, putState, strings.Join([]string{configPrefix, configContract}, "."), mock.AnythingOfType("[]uint8"))
+	})
+	t.Run("After SetAccessPolicy", func(t *testing.T) {
+		cfg := DefaultConfig()
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.SetAccessPolicy(ctx, `{"roles":{"doctor":["MedicalStationMSP"],"patient":["PatientsMSP"],"admin":["MedicalStationMSP","AuditMSP"]}}`)
+		assert.Nil(t, err)
+		stored := ContractConfig{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{configPrefix, configContract}, ".")), &stored))
+
+		ctx, ms = setupTestConfig(defaultDoctorMSP, RoleAdmin, &stored)
+		err = c.UpdateConfig(ctx, `{"network":{"doctor_mspid":"MedicalStationMSP","patient_mspid":"PatientsMSP"},"requestRetentionDays":7}`)
+		assert.Nil(t, err)
+		updated := ContractConfig{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{configPrefix, configContract}, ".")), &updated))
+		assert.Equal(t, []string{"MedicalStationMSP", "AuditMSP"}, updated.Policy.Roles[RoleAdmin])
+		assert.Equal(t, 7, updated.RequestRetentionDays)
+	})
+	t.Run("Omitted fields survive", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.RequestRetentionDays = 7
+		cfg.Sites = map[string]SiteConfig{"north": {Capacity: 100}, "south": {Capacity: 20}}
+		cfg.Vaccines = map[VaccinationType]VaccineConfig{Alpha: {Doses: 3}}
+		cfg.Compatibility = []CompatibilityRule{{Previous: Alpha, Next: Alpha}}
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.UpdateConfig(ctx, `{"sites":{"north":{"capacity":50}}}`)
+		assert.Nil(t, err)
+
+		updated := ContractConfig{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{configPrefix, configContract}, ".")), &updated))
+		assert.Equal(t, map[string]SiteConfig{"north": {Capacity: 50}}, updated.Sites)
+		assert.Equal(t, cfg.Vaccines, updated.Vaccines)
+		assert.Equal(t, cfg.Compatibility, updated.Compatibility)
+		assert.Equal(t, cfg.Network, updated.Network)
+		assert.Equal(t, cfg.Policy, updated.Policy)
+		assert.Equal(t, 7, updated.RequestRetentionDays)
+	})
+	t.Run("Reset to default", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.RequestRetentionDays = 7
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.UpdateConfig(ctx, `{"requestRetentionDays":null}`)
+		assert.Nil(t, err)
+
+		updated := ContractConfig{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{configPrefix, configContract}, ".")), &updated))
+		assert.Equal(t, defaultRequestRetentionDays, updated.RequestRetentionDays)
+	})
+	t.Run("Invalid JSON", func(t *testing.T) {
+		cfg := DefaultConfig()
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.UpdateConfig(ctx, `[]`)
+		assert.True(t, errors.Is(err, contracterr.InvalidArgument))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("No admin", func(t *testing.T) {
+		cfg := DefaultConfig()
+		ctx, ms := setupTestConfig(defaultDoctorMSP, RoleAdmin, &cfg)
+		c := &VaccinationContract{}
+		err := c.UpdateConfig(ctx, `{"network":{"doctor_mspid":"HospitalMSP","patient_mspid":"PatientsMSP"},"policy":{"roles":{"doctor":["HospitalMSP"]}}}`)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+func setupTestConfig(mspid string, role Role, cfg *ContractConfig) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+
+	configBytes := []byte{}
+	if cfg != nil {
+		configBytes, _ = json.Marshal(cfg)
+	}
+	key := strings.Join([]string{configPrefix, configContract}, ".")
+	ms.On(createCompositeKey, configPrefix, []string{configContract}).Return(key, nil)
+	ms.On(getState, key).Return(configBytes, nil)
+	ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mci.On(getMSPID).Return(mspid, nil)
+	mci.On(getAttributeValue, roleAttribute).Return(string(role), len(role) > 0, nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
 //<editor-fold desc="Test IssueSlot">
 func TestIssueSlot(t *testing.T) {
 	t.Run("Correct", func(t *testing.T) {
@@ -255,19 +776,23 @@ func TestIssueSlot(t *testing.T) {
 		assert.Error(t, err)
 		ms.AssertNotCalled(t, setEvent, "Transfer", mock.Anything)
 	})
-	//t.Run("Wrong vaccine", func(t *testing.T) {
-	//	ctx, ms, gen := setupTestIssueSlot1()
-	//	c := &VaccinationContract{
-	//		IdGenerator: gen,
-	//	}
-	//	_, err := c.IssueSlot(ctx, "macskakaja", "2000-01-01", patient1)
-	//	assert.Error(t, err)
-	//	ms.AssertNotCalled(t, "SetEvent", mock.Anything)
-	//})
+	t.Run("Wrong vaccine", func(t *testing.T) {
+		ctx, ms, gen := setupTestIssueSlot1()
+		c := &VaccinationContract{
+			IdGenerator: gen,
+		}
+		_, err := c.IssueSlot(ctx, "macskakaja", "2000-01-01", patient1, "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, setEvent, "Transfer", mock.Anything)
+	})
 }
 
 func setupTestIssueSlot1() (*MockContext, *MockStub, *MockTokenIdGenerator) {
 	ms := &MockStub{}
+	ms.On(getTransient).Return(map[string][]byte{}, nil)
+	mockPseudonymSecret(ms)
+	mockSiteDate(ms)
+	mockSeries(ms)
 	gen := &MockTokenIdGenerator{
 		[]string{slot1, slot2},
 	}
@@ -275,8 +800,8 @@ func setupTestIssueSlot1() (*MockContext, *MockStub, *MockTokenIdGenerator) {
 	anyBytes := mock.AnythingOfType("[]uint8")
 
 	patient1Balance := &MockIterator{}
-	patient164 := base64.StdEncoding.EncodeToString([]byte(patient1))
-	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{patient164}).Return(patient1Balance, nil)
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(patient1Balance, nil)
 	{
 		key := strings.Join([]string{vsPrefix, "slot1"}, ".")
 		ms.On(createCompositeKey, vsPrefix, []string{"slot1"}).Return(key, nil)
@@ -284,14 +809,14 @@ func setupTestIssueSlot1() (*MockContext, *MockStub, *MockTokenIdGenerator) {
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	{
-		key := strings.Join([]string{balancePrefix, patient164, "slot1"}, ".")
-		ms.On(createCompositeKey, balancePrefix, []string{patient164, "slot1"}).Return(key, nil)
+		key := strings.Join([]string{balancePrefix, pseudonym164, "slot1"}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, "slot1"}).Return(key, nil)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	ms.On(setEvent, "Transfer", anyBytes).Return(nil)
 
 	mci := &MockClientIdentity{}
-	mci.On(getMSPID).Return("MedicalStationMSP", nil)
+	mockRole(ms, mci, defaultDoctorMSP)
 
 	mc := &MockContext{}
 	mc.On(getStub).Return(ms)
@@ -307,7 +832,7 @@ func setupTestIssueSlot2() (*MockContext, *MockStub, TokenIdGeneratorInterface)
 	}
 
 	mci := &MockClientIdentity{}
-	mci.On(getMSPID).Return("SomethingWrong", nil)
+	mockRole(ms, mci, "SomethingWrong")
 
 	mc := &MockContext{}
 	mc.On(getStub).Return(ms)
@@ -318,6 +843,8 @@ func setupTestIssueSlot2() (*MockContext, *MockStub, TokenIdGeneratorInterface)
 
 func setupTestIssueSlot3() (*MockContext, *MockStub, TokenIdGeneratorInterface) {
 	ms := &MockStub{}
+	ms.On(getTransient).Return(map[string][]byte{}, nil)
+	mockPseudonymSecret(ms)
 	gen := &MockTokenIdGenerator{
 		[]string{slot1, slot2},
 	}
@@ -336,15 +863,15 @@ func setupTestIssueSlot3() (*MockContext, *MockStub, TokenIdGeneratorInterface)
 			}(),
 		},
 		TokenId: slot1,
-		Owner:   patient1,
+		Owner:   pseudonym1,
 	}
 
 	vsb, _ := json.Marshal(vs)
 
-	patient164 := base64.StdEncoding.EncodeToString([]byte(patient1))
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
 
 	{
-		key := strings.Join([]string{balancePrefix, patient164, slot1}, ".")
+		key := strings.Join([]string{balancePrefix, pseudonym164, slot1}, ".")
 		patient1Balance := &MockIterator{
 			queries: []queryresult.KV{
 				{
@@ -353,7 +880,7 @@ func setupTestIssueSlot3() (*MockContext, *MockStub, TokenIdGeneratorInterface)
 				},
 			},
 		}
-		ms.On(getStateByPartialCompositeKey, balancePrefix, []string{patient164}).Return(patient1Balance, nil)
+		ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(patient1Balance, nil)
 	}
 	{
 		key := strings.Join([]string{vsPrefix, "slot1"}, ".")
@@ -362,14 +889,14 @@ func setupTestIssueSlot3() (*MockContext, *MockStub, TokenIdGeneratorInterface)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	{
-		key := strings.Join([]string{balancePrefix, patient164, "slot1"}, ".")
-		ms.On(createCompositeKey, balancePrefix, []string{patient164, "slot1"}).Return(key, nil)
+		key := strings.Join([]string{balancePrefix, pseudonym164, "slot1"}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, "slot1"}).Return(key, nil)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	ms.On(setEvent, "Transfer", anyBytes).Return(nil)
 
 	mci := &MockClientIdentity{}
-	mci.On(getMSPID).Return("MedicalStationMSP", nil)
+	mockRole(ms, mci, defaultDoctorMSP)
 
 	mc := &MockContext{}
 	mc.On(getStub).Return(ms)
@@ -380,76 +907,167 @@ func setupTestIssueSlot3() (*MockContext, *MockStub, TokenIdGeneratorInterface)
 
 //</editor-fold>
 
-//<editor-fold desc="Test MakeOffer">
-func TestMakeOffer(t *testing.T) {
+//<editor-fold desc="Test IssueSlotTransient">
+func TestIssueSlotTransient(t *testing.T) {
+	transient := map[string][]byte{
+		transientVaccine: []byte("delta"),
+		transientDate:    []byte("2000-01-01"),
+		transientPatient: []byte(patient1),
+	}
 	t.Run("Correct", func(t *testing.T) {
-		ctx, _, gen := setupTestMakeOffer1(patient1)
+		ctx, ms, gen := setupTestIssueSlotTransient(transient, "MedicalStationMSP")
 		c := &VaccinationContract{
 			IdGenerator: gen,
 		}
-		offer, err := c.MakeOffer(ctx, slot1, patient2, slot2)
+		slot, err := c.IssueSlotTransient(ctx)
 		assert.Nil(t, err)
-		assert.NotEmpty(t, offer)
+		assert.Equal(t, slot1, slot)
+		ms.AssertCalled(t, putPrivateData, patientCollection, mock.Anything, mock.AnythingOfType("[]uint8"))
+		ms.AssertCalled(t, setEvent, "Transfer", mock.AnythingOfType("[]uint8"))
 	})
-	t.Run("Wrong recipient", func(t *testing.T) {
-		ctx, _, gen := setupTestMakeOffer1(patient1)
+	t.Run("Missing patient", func(t *testing.T) {
+		incomplete := map[string][]byte{}
+		for k, v := range transient {
+			incomplete[k] = v
+		}
+		delete(incomplete, transientPatient)
+		ctx, ms, gen := setupTestIssueSlotTransient(incomplete, "MedicalStationMSP")
 		c := &VaccinationContract{
 			IdGenerator: gen,
 		}
-		_, err := c.MakeOffer(ctx, slot1, patient2, slot1)
+		_, err := c.IssueSlotTransient(ctx)
 		assert.Error(t, err)
+		ms.AssertNotCalled(t, putPrivateData, mock.Anything, mock.Anything, mock.Anything)
+		ms.AssertNotCalled(t, setEvent, "Transfer", mock.Anything)
 	})
-	t.Run("Wrong sender", func(t *testing.T) {
-		ctx, _, gen := setupTestMakeOffer1(patient1)
+	t.Run("Wrong MSPID", func(t *testing.T) {
+		ctx, ms, gen := setupTestIssueSlotTransient(transient, "SomethingWrong")
 		c := &VaccinationContract{
 			IdGenerator: gen,
 		}
-		_, err := c.MakeOffer(ctx, slot2, patient2, slot2)
+		_, err := c.IssueSlotTransient(ctx)
 		assert.Error(t, err)
+		ms.AssertNotCalled(t, setEvent, "Transfer", mock.Anything)
 	})
-
 }
 
-func setupTestMakeOffer1(patient string) (*MockContext, *MockStub, TokenIdGeneratorInterface) {
+func setupTestIssueSlotTransient(transient map[string][]byte, mspid string) (*MockContext, *MockStub, TokenIdGeneratorInterface) {
 	ms := &MockStub{}
-
+	mockPseudonymSecret(ms)
+	mockSiteDate(ms)
+	mockSeries(ms)
 	gen := &MockTokenIdGenerator{
-		[]string{offer1},
+		[]string{slot1, slot2},
 	}
 
 	anyBytes := mock.AnythingOfType("[]uint8")
 
-	newDate := func(str string) VaccinationDate {
-		value, err := time.Parse("2006-01-02", str)
-		if err != nil {
-			log.Fatal(err)
-		}
-		return VaccinationDate(value)
-	}
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
 
-	vs1 := &VaccinationSlot{
-		VaccinationSlotData: VaccinationSlotData{
-			Type: Alpha,
-			Date: newDate("2000-01-01"),
-		},
-		TokenId: slot1,
-		Owner:   patient,
+	ms.On(getTransient).Return(transient, nil)
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(&MockIterator{}, nil)
+	{
+		key := strings.Join([]string{vsPrefix, slot1}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot1}).Return(key, nil)
+		ms.On(getState, key).Return([]byte{}, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
 	}
-
-	vs2 := &VaccinationSlot{
-		VaccinationSlotData: VaccinationSlotData{
-			Type: Bravo,
-			Date: newDate("2000-01-02"),
-		},
-		TokenId: slot2,
-		Owner:   patient2,
+	{
+		key := strings.Join([]string{balancePrefix, pseudonym164, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, slot1}).Return(key, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
+	}
+	{
+		key := strings.Join([]string{patientPrefix, pseudonym1}, ".")
+		ms.On(createCompositeKey, patientPrefix, []string{pseudonym1}).Return(key, nil)
+		ms.On(putPrivateData, patientCollection, key, anyBytes).Return(nil)
+	}
+	ms.On(setEvent, "Transfer", anyBytes).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, mspid)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms, gen
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test MakeOffer">
+func TestMakeOffer(t *testing.T) {
+	t.Run("Correct", func(t *testing.T) {
+		ctx, _, gen := setupTestMakeOffer1(patient1)
+		c := &VaccinationContract{
+			IdGenerator: gen,
+		}
+		offer, err := c.MakeOffer(ctx, slot1, pseudonym2, slot2)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, offer)
+	})
+	t.Run("Wrong recipient", func(t *testing.T) {
+		ctx, _, gen := setupTestMakeOffer1(patient1)
+		c := &VaccinationContract{
+			IdGenerator: gen,
+		}
+		_, err := c.MakeOffer(ctx, slot1, pseudonym2, slot1)
+		assert.Error(t, err)
+	})
+	t.Run("Wrong sender", func(t *testing.T) {
+		ctx, _, gen := setupTestMakeOffer1(patient1)
+		c := &VaccinationContract{
+			IdGenerator: gen,
+		}
+		_, err := c.MakeOffer(ctx, slot2, pseudonym2, slot2)
+		assert.Error(t, err)
+	})
+
+}
+
+func setupTestMakeOffer1(patient string) (*MockContext, *MockStub, TokenIdGeneratorInterface) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	ms.On(getTransient).Return(map[string][]byte{}, nil)
+
+	gen := &MockTokenIdGenerator{
+		[]string{offer1},
+	}
+
+	anyBytes := mock.AnythingOfType("[]uint8")
+
+	newDate := func(str string) VaccinationDate {
+		value, err := time.Parse("2006-01-02", str)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return VaccinationDate(value)
+	}
+
+	vs1 := &VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type: Alpha,
+			Date: newDate("2000-01-01"),
+		},
+		TokenId: slot1,
+		Owner:   pseudonymOf(patient),
+	}
+
+	vs2 := &VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type: Bravo,
+			Date: newDate("2000-01-02"),
+		},
+		TokenId: slot2,
+		Owner:   pseudonym2,
 	}
 
 	vsb1, _ := json.Marshal(&vs1)
 	vbs2, _ := json.Marshal(&vs2)
 
-	patient164 := base64.StdEncoding.EncodeToString([]byte(patient1))
-	patient264 := base64.StdEncoding.EncodeToString([]byte(patient2))
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	pseudonym264 := base64.StdEncoding.EncodeToString([]byte(pseudonym2))
 
 	{
 		key := strings.Join([]string{vsPrefix, slot1}, ".")
@@ -462,13 +1080,13 @@ func setupTestMakeOffer1(patient string) (*MockContext, *MockStub, TokenIdGenera
 		ms.On(getState, key).Return(vbs2, nil)
 	}
 	{
-		key := strings.Join([]string{offerPrefix, patient164, offer1}, ".")
-		ms.On(createCompositeKey, offerPrefix, []string{patient164, offer1}).Return(key, nil)
+		key := strings.Join([]string{offerPrefix, pseudonym164, offer1}, ".")
+		ms.On(createCompositeKey, offerPrefix, []string{pseudonym164, offer1}).Return(key, nil)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	{
-		key := strings.Join([]string{offerPrefix, patient264, offer1}, ".")
-		ms.On(createCompositeKey, offerPrefix, []string{patient264, offer1}).Return(key, nil)
+		key := strings.Join([]string{offerPrefix, pseudonym264, offer1}, ".")
+		ms.On(createCompositeKey, offerPrefix, []string{pseudonym264, offer1}).Return(key, nil)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	{
@@ -480,6 +1098,7 @@ func setupTestMakeOffer1(patient string) (*MockContext, *MockStub, TokenIdGenera
 	patient64 := base64.StdEncoding.EncodeToString([]byte(patient))
 
 	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultPatientMSP)
 	mci.On(getID).Return(patient64, nil)
 
 	mc := &MockContext{}
@@ -506,7 +1125,7 @@ func TestAcceptOffer(t *testing.T) {
 			Date: newDate("2050-02-01"),
 		},
 		TokenId: slot1,
-		Owner:   patient1,
+		Owner:   pseudonym1,
 	}
 
 	vs2 := VaccinationSlot{
@@ -515,7 +1134,7 @@ func TestAcceptOffer(t *testing.T) {
 			Date: newDate("2050-02-02"),
 		},
 		TokenId: slot2,
-		Owner:   patient2,
+		Owner:   pseudonym2,
 	}
 	t.Run("Correct", func(t *testing.T) {
 		ctx, ms, gen, _ := setupTestAcceptOffer1(vs1, vs2)
@@ -550,7 +1169,8 @@ func TestAcceptOffer(t *testing.T) {
 	})
 	t.Run("Burned", func(t *testing.T) {
 		vs1 := vs1
-		vs1.Burned = true
+		vs1.Status = StatusAdministered
+		vs1.Version = SchemaVersion
 		ctx, ms, gen, _ := setupTestAcceptOffer1(vs1, vs2)
 		c := &VaccinationContract{
 			IdGenerator: gen,
@@ -588,7 +1208,15 @@ func TestAcceptOffer(t *testing.T) {
 }
 
 func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockContext, *MockStub, TokenIdGeneratorInterface, []byte) {
+	return setupTestSwap(vs1, vs2)
+}
+
+// setupTestSwap mocks offer1 of slot2 of pseudonym2 for slot1 of pseudonym1,
+// others are further slots in the balance of their owners.
+func setupTestSwap(vs1 VaccinationSlot, vs2 VaccinationSlot, others ...VaccinationSlot) (*MockContext, *MockStub, TokenIdGeneratorInterface, []byte) {
 	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockTxTime(ms, "2026-10-01")
 
 	gen := &MockTokenIdGenerator{
 		[]string{offer1},
@@ -613,7 +1241,7 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 			Date: newDate("2050-01-15"),
 		},
 		TokenId: slot3,
-		Owner:   patient1,
+		Owner:   pseudonym1,
 	}
 	vs4 := VaccinationSlot{
 		VaccinationSlotData: VaccinationSlotData{
@@ -621,7 +1249,7 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 			Date: newDate("2049-01-01"),
 		},
 		TokenId: slot4,
-		Owner:   patient1,
+		Owner:   pseudonym1,
 	}
 
 	vsb3, _ := json.Marshal(&vs3)
@@ -629,12 +1257,29 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 
 	vs22 := vs2
 	vs22.Type = vs1.Type
-	vs22.Owner = patient1
+	vs22.Owner = pseudonym1
 	vs22.Previous = slot3
+	vs22.Status = StatusIssued
+	vs22.Version = SchemaVersion
 	vsb22, _ := json.Marshal(&vs22)
 
-	patient164 := base64.StdEncoding.EncodeToString([]byte(patient1))
-	patient264 := base64.StdEncoding.EncodeToString([]byte(patient2))
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	pseudonym264 := base64.StdEncoding.EncodeToString([]byte(pseudonym2))
+
+	balances := make(map[string][]queryresult.KV)
+	for _, vs := range append([]VaccinationSlot{vs1, vs2}, others...) {
+		balances[vs.Owner] = append(balances[vs.Owner], queryresult.KV{Key: vs.TokenId, Value: []byte(vs.TokenId)})
+	}
+	for _, owner := range []string{pseudonym1, pseudonym2} {
+		owner64 := base64.StdEncoding.EncodeToString([]byte(owner))
+		ms.On(getStateByPartialCompositeKey, balancePrefix, []string{owner64}).Return(&MockIterator{queries: balances[owner]}, nil)
+	}
+	for _, vs := range others {
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		vsb, _ := json.Marshal(&vs)
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+	}
 
 	{
 		key := strings.Join([]string{vsPrefix, slot1}, ".")
@@ -660,33 +1305,33 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 		ms.On(getState, key).Return(vsb4, nil)
 	}
 	{
-		key := strings.Join([]string{balancePrefix, patient164, slot1}, ".")
-		ms.On(createCompositeKey, balancePrefix, []string{patient164, slot1}).Return(key, nil)
+		key := strings.Join([]string{balancePrefix, pseudonym164, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, slot1}).Return(key, nil)
 		ms.On(delState, key).Return(nil)
 	}
 	{
-		key := strings.Join([]string{balancePrefix, patient264, slot2}, ".")
-		ms.On(createCompositeKey, balancePrefix, []string{patient264, slot2}).Return(key, nil)
+		key := strings.Join([]string{balancePrefix, pseudonym264, slot2}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym264, slot2}).Return(key, nil)
 		ms.On(delState, key).Return(nil)
 	}
 	{
-		key := strings.Join([]string{balancePrefix, patient164, slot2}, ".")
-		ms.On(createCompositeKey, balancePrefix, []string{patient164, slot2}).Return(key, nil)
+		key := strings.Join([]string{balancePrefix, pseudonym164, slot2}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, slot2}).Return(key, nil)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	{
-		key := strings.Join([]string{balancePrefix, patient264, slot1}, ".")
-		ms.On(createCompositeKey, balancePrefix, []string{patient264, slot1}).Return(key, nil)
+		key := strings.Join([]string{balancePrefix, pseudonym264, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym264, slot1}).Return(key, nil)
 		ms.On(putState, key, anyBytes).Return(nil)
 	}
 	{
-		key := strings.Join([]string{offerPrefix, patient164, offer1}, ".")
-		ms.On(createCompositeKey, offerPrefix, []string{patient164, offer1}).Return(key, nil)
+		key := strings.Join([]string{offerPrefix, pseudonym164, offer1}, ".")
+		ms.On(createCompositeKey, offerPrefix, []string{pseudonym164, offer1}).Return(key, nil)
 		ms.On(delState, key).Return(nil)
 	}
 	{
-		key := strings.Join([]string{offerPrefix, patient264, offer1}, ".")
-		ms.On(createCompositeKey, offerPrefix, []string{patient264, offer1}).Return(key, nil)
+		key := strings.Join([]string{offerPrefix, pseudonym264, offer1}, ".")
+		ms.On(createCompositeKey, offerPrefix, []string{pseudonym264, offer1}).Return(key, nil)
 		ms.On(delState, key).Return(nil)
 	}
 	{
@@ -695,9 +1340,9 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 		ms.On(delState, key).Return(nil)
 		offer := &TradeOffer{
 			Uuid:          offer1,
-			Sender:        patient2,
+			Sender:        pseudonym2,
 			SenderItem:    slot2,
-			Recipient:     patient1,
+			Recipient:     pseudonym1,
 			RecipientItem: slot1,
 		}
 		offerBytes, _ := json.Marshal(offer)
@@ -710,8 +1355,8 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 	}
 	{
 		transfer := &Transfer{
-			From:    patient1,
-			To:      patient2,
+			From:    pseudonym1,
+			To:      pseudonym2,
 			TokenId: slot1,
 		}
 		transferBytes, _ := json.Marshal(transfer)
@@ -719,8 +1364,8 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 	}
 	{
 		transfer := &Transfer{
-			From:    patient2,
-			To:      patient1,
+			From:    pseudonym2,
+			To:      pseudonym1,
 			TokenId: slot2,
 		}
 		transferBytes, _ := json.Marshal(transfer)
@@ -728,7 +1373,8 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 	}
 
 	mci := &MockClientIdentity{}
-	mci.On(getID).Return(patient164, nil)
+	mockRole(ms, mci, defaultPatientMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(patient1)), nil)
 
 	mc := &MockContext{}
 	mc.On(getStub).Return(ms)
@@ -739,89 +1385,2567 @@ func setupTestAcceptOffer1(vs1 VaccinationSlot, vs2 VaccinationSlot) (*MockConte
 
 //</editor-fold>
 
-//<editor-fold desc="Test ListOffers">
-func TestListOffer(t *testing.T) {
-	t.Run("Patient1 2 slot", func(t *testing.T) {
-		ctx, _ := setupTestListOffers(patient1)
-		c := &VaccinationContract{}
-		offersStr, err := c.ListOffers(ctx)
+//<editor-fold desc="Test CheckOffer">
+func TestCheckOffer(t *testing.T) {
+	vs1 := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type: Alpha,
+			Date: VaccinationDate(time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		TokenId: slot1,
+		Owner:   pseudonym1,
+	}
+	vs2 := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type: Bravo,
+			Date: VaccinationDate(time.Date(2050, 2, 2, 0, 0, 0, 0, time.UTC)),
+		},
+		TokenId: slot2,
+		Owner:   pseudonym2,
+	}
+	check := func(t *testing.T, vs1, vs2 VaccinationSlot) (OfferCheck, *MockStub) {
+		ctx, ms, gen, _ := setupTestAcceptOffer1(vs1, vs2)
+		c := &VaccinationContract{IdGenerator: gen}
+		resultJSON, err := c.CheckOffer(ctx, offer1)
 		assert.Nil(t, err)
-		offers := make([]TradeOffer, 0)
-		err = json.Unmarshal([]byte(offersStr), &offers)
+		result := OfferCheck{}
+		assert.Nil(t, json.Unmarshal([]byte(resultJSON), &result))
+		return result, ms
+	}
+	rules := func(result OfferCheck) []string {
+		names := make([]string, 0)
+		for _, violation := range result.Violations {
+			names = append(names, violation.Rule)
+		}
+		return names
+	}
+
+	t.Run("Acceptable", func(t *testing.T) {
+		result, ms := check(t, vs1, vs2)
+		assert.True(t, result.Acceptable)
+		assert.Empty(t, result.Violations)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+		ms.AssertNotCalled(t, delState, mock.Anything)
+	})
+	t.Run("Every violation", func(t *testing.T) {
+		vs1 := vs1
+		vs1.Date = VaccinationDate(time.Date(2000, 4, 25, 0, 0, 0, 0, time.UTC))
+		vs1.Status = StatusAdministered
+		vs1.Version = SchemaVersion
+		vs2 := vs2
+		vs2.Owner = pseudonym3
+		result, _ := check(t, vs1, vs2)
+		assert.False(t, result.Acceptable)
+		assert.Equal(t, []string{"sender-owner", "recipient-status", "recipient-expiry"}, rules(result))
+		assert.Equal(t, contracterr.Conflict, result.Violations[0].Code)
+		assert.Equal(t, contracterr.RuleViolation, result.Violations[1].Code)
+		assert.Equal(t, contracterr.Expired, result.Violations[2].Code)
+	})
+	t.Run("Deadline", func(t *testing.T) {
+		vs1 := vs1
+		vs1.Previous = slot4
+		result, _ := check(t, vs1, vs2)
+		assert.False(t, result.Acceptable)
+		assert.Equal(t, []string{"sender-interval"}, rules(result))
+	})
+	t.Run("AcceptOffer stops at the first violation", func(t *testing.T) {
+		vs1 := vs1
+		vs1.Previous = slot4
+		ctx, ms, gen, _ := setupTestAcceptOffer1(vs1, vs2)
+		c := &VaccinationContract{IdGenerator: gen}
+		err := c.AcceptOffer(ctx, offer1)
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test same-day swaps">
+func TestSameDaySwap(t *testing.T) {
+	date := func(day int) VaccinationDate {
+		return VaccinationDate(time.Date(2050, 2, day, 0, 0, 0, 0, time.UTC))
+	}
+	vs1 := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(1), Status: StatusIssued},
+		TokenId:             slot1,
+		Owner:               pseudonym1,
+		Version:             SchemaVersion,
+	}
+	vs2 := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{Type: Bravo, Date: date(2), Status: StatusIssued},
+		TokenId:             slot2,
+		Owner:               pseudonym2,
+		Version:             SchemaVersion,
+	}
+	other := func(owner string, day int, status SlotStatus) VaccinationSlot {
+		return VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{Type: Charlie, Date: date(day), Status: status},
+			TokenId:             slot5,
+			Owner:               owner,
+			Version:             SchemaVersion,
+		}
+	}
+	violations := func(t *testing.T, ctx *MockContext, c *VaccinationContract) []string {
+		resultJSON, err := c.CheckOffer(ctx, offer1)
 		assert.Nil(t, err)
-		assert.Equal(t, 2, len(offers))
+		result := OfferCheck{}
+		assert.Nil(t, json.Unmarshal([]byte(resultJSON), &result))
+		rules := make([]string, 0)
+		for _, violation := range result.Violations {
+			rules = append(rules, violation.Rule)
+		}
+		return rules
+	}
+
+	t.Run("Recipient has a slot on the received date", func(t *testing.T) {
+		ctx, ms, gen, _ := setupTestSwap(vs1, vs2, other(pseudonym1, 2, StatusIssued))
+		c := &VaccinationContract{IdGenerator: gen}
+		err := c.AcceptOffer(ctx, offer1)
+		assert.ErrorIs(t, err, contracterr.Conflict)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+
+		ctx, _, _, _ = setupTestSwap(vs1, vs2, other(pseudonym1, 2, StatusAdministered))
+		assert.Equal(t, []string{"recipient-date"}, violations(t, ctx, c))
 	})
-	t.Run("Patient2 2 slot", func(t *testing.T) {
-		ctx, _ := setupTestListOffers(patient2)
+	t.Run("Sender has a slot on the received date", func(t *testing.T) {
+		ctx, ms, gen, _ := setupTestSwap(vs1, vs2, other(pseudonym2, 1, StatusIssued))
+		c := &VaccinationContract{IdGenerator: gen}
+		err := c.AcceptOffer(ctx, offer1)
+		assert.ErrorIs(t, err, contracterr.Conflict)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+
+		ctx, _, _, _ = setupTestSwap(vs1, vs2, other(pseudonym2, 1, StatusIssued))
+		assert.Equal(t, []string{"sender-date"}, violations(t, ctx, c))
+	})
+	t.Run("Cancelled slot on the received date", func(t *testing.T) {
+		ctx, ms, gen, _ := setupTestSwap(vs1, vs2, other(pseudonym1, 2, StatusCancelled))
+		c := &VaccinationContract{IdGenerator: gen}
+		assert.Nil(t, c.AcceptOffer(ctx, offer1))
+		ms.AssertNumberOfCalls(t, setEvent, 2)
+	})
+	t.Run("Same date", func(t *testing.T) {
+		vs2 := vs2
+		vs2.Date = vs1.Date
+		ctx, ms, gen, _ := setupTestSwap(vs1, vs2)
+		c := &VaccinationContract{IdGenerator: gen}
+		assert.Nil(t, c.AcceptOffer(ctx, offer1))
+		ms.AssertNumberOfCalls(t, setEvent, 2)
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test compatibility">
+func TestCompatibility(t *testing.T) {
+	date := func(month time.Month, day int) VaccinationDate {
+		return VaccinationDate(time.Date(2050, month, day, 0, 0, 0, 0, time.UTC))
+	}
+	cfg := DefaultConfig()
+	cfg.Compatibility = []CompatibilityRule{
+		{Previous: Alpha, Next: Alpha},
+		{Previous: Alpha, Next: Charlie},
+	}
+
+	ms := &MockStub{}
+	prev := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(1, 1), Status: StatusAdministered},
+		TokenId:             slot3,
+		Owner:               pseudonym1,
+		Version:             SchemaVersion,
+	}
+	prevBytes, _ := json.Marshal(&prev)
+	key := strings.Join([]string{vsPrefix, slot3}, ".")
+	ms.On(createCompositeKey, vsPrefix, []string{slot3}).Return(key, nil)
+	ms.On(getState, key).Return(prevBytes, nil)
+	ctx := &MockContext{}
+	ctx.On(getStub).Return(ms)
+
+	t.Run("Validation", func(t *testing.T) {
+		for _, rules := range [][]CompatibilityRule{
+			{{Previous: Alpha, Next: "omega"}},
+			{{Previous: Alpha, Next: Bravo}, {Previous: Alpha, Next: Bravo}},
+		} {
+			invalid := cfg
+			invalid.Compatibility = rules
+			cfgBytes, _ := json.Marshal(invalid)
+			_, err := parseConfig(string(cfgBytes))
+			assert.ErrorIs(t, err, contracterr.InvalidArgument)
+		}
+		cfgBytes, _ := json.Marshal(cfg)
+		_, err := parseConfig(string(cfgBytes))
+		assert.Nil(t, err)
+	})
+	t.Run("Pairs", func(t *testing.T) {
+		assert.Nil(t, checkCompatibility(ctx, cfg, slot3, Alpha))
+		assert.Nil(t, checkCompatibility(ctx, cfg, slot3, Charlie))
+		assert.ErrorIs(t, checkCompatibility(ctx, cfg, slot3, Bravo), contracterr.RuleViolation)
+		assert.Nil(t, checkCompatibility(ctx, cfg, "", Bravo))
+	})
+	t.Run("Without matrix", func(t *testing.T) {
+		assert.Nil(t, checkCompatibility(ctx, DefaultConfig(), slot3, Alpha))
+		assert.ErrorIs(t, checkCompatibility(ctx, DefaultConfig(), slot3, Delta), contracterr.RuleViolation)
+	})
+	t.Run("Issuance", func(t *testing.T) {
 		c := &VaccinationContract{}
-		offersStr, err := c.ListOffers(ctx)
+		_, err := c.checkIssue(ctx, cfg, string(Bravo), "2050-01-25", "", pseudonym1, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+	})
+	t.Run("Swap", func(t *testing.T) {
+		rules := make(map[string]TradeRule)
+		for _, rule := range BaseTradeRules() {
+			rules[rule.Name] = rule
+		}
+		senderSlot := &VaccinationSlot{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(1, 10), Previous: slot3}}
+		recipientSlot := &VaccinationSlot{VaccinationSlotData: VaccinationSlotData{Type: Bravo, Date: date(1, 25)}}
+		trade := &Trade{SenderSlot: senderSlot, RecipientSlot: recipientSlot, Config: cfg}
+		assert.Nil(t, rules["sender-compatibility"].Check(ctx, trade))
+		assert.Nil(t, rules["recipient-compatibility"].Check(ctx, trade))
+
+		senderSlot.Type = Bravo
+		assert.ErrorIs(t, rules["sender-compatibility"].Check(ctx, trade), contracterr.RuleViolation)
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test dose interval">
+func TestDoseInterval(t *testing.T) {
+	date := func(month time.Month, day int) VaccinationDate {
+		return VaccinationDate(time.Date(2050, month, day, 0, 0, 0, 0, time.UTC))
+	}
+
+	ms := &MockStub{}
+	prev := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(1, 1), Status: StatusAdministered},
+		TokenId:             slot3,
+		Owner:               pseudonym1,
+		Version:             SchemaVersion,
+	}
+	prevBytes, _ := json.Marshal(&prev)
+	key := strings.Join([]string{vsPrefix, slot3}, ".")
+	ms.On(createCompositeKey, vsPrefix, []string{slot3}).Return(key, nil)
+	ms.On(getState, key).Return(prevBytes, nil)
+	ctx := &MockContext{}
+	ctx.On(getStub).Return(ms)
+
+	t.Run("Validation", func(t *testing.T) {
+		for _, vaccines := range []map[VaccinationType]VaccineConfig{
+			{"omega": {MinIntervalDays: 1}},
+			{Alpha: {MinIntervalDays: -1}},
+			{Alpha: {MinIntervalDays: 30, MaxIntervalDays: 20}},
+		} {
+			cfg := DefaultConfig()
+			cfg.Vaccines = vaccines
+			cfgBytes, _ := json.Marshal(cfg)
+			_, err := parseConfig(string(cfgBytes))
+			assert.ErrorIs(t, err, contracterr.InvalidArgument)
+		}
+	})
+	t.Run("Built-in", func(t *testing.T) {
+		cfg := DefaultConfig()
+		assert.Nil(t, checkInterval(ctx, cfg, slot3, date(1, 15)))
+		assert.Nil(t, checkInterval(ctx, cfg, slot3, date(1, 31)))
+		assert.Nil(t, checkInterval(ctx, cfg, "", date(1, 2)))
+
+		err := checkInterval(ctx, cfg, slot3, date(1, 10))
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+		assert.Contains(t, contracterr.MessageOf(err), "too early")
+		err = checkInterval(ctx, cfg, slot3, date(2, 1))
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+		assert.Contains(t, contracterr.MessageOf(err), "too late")
+	})
+	t.Run("Configured", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Vaccines = map[VaccinationType]VaccineConfig{Alpha: {MinIntervalDays: 21, MaxIntervalDays: 60}}
+		assert.ErrorIs(t, checkInterval(ctx, cfg, slot3, date(1, 15)), contracterr.RuleViolation)
+		assert.Nil(t, checkInterval(ctx, cfg, slot3, date(2, 15)))
+	})
+	t.Run("Configured minimum", func(t *testing.T) {
+		assert.Equal(t, defaultMinIntervalDays, DefaultConfig().MinIntervalDays)
+
+		cfg := DefaultConfig()
+		cfg.MinIntervalDays = 7
+		assert.Nil(t, checkInterval(ctx, cfg, slot3, date(1, 10)))
+		assert.ErrorIs(t, checkInterval(ctx, cfg, slot3, date(1, 5)), contracterr.RuleViolation)
+
+		cfg.MinIntervalDays = -1
+		cfgBytes, _ := json.Marshal(cfg)
+		_, err := parseConfig(string(cfgBytes))
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+	})
+	t.Run("Unknown previous type", func(t *testing.T) {
+		unknown := prev
+		unknown.TokenId = slot1
+		unknown.Type = "omega"
+		unknownBytes, _ := json.Marshal(&unknown)
+		unknownKey := strings.Join([]string{vsPrefix, slot1}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot1}).Return(unknownKey, nil)
+		ms.On(getState, unknownKey).Return(unknownBytes, nil)
+
+		err := checkInterval(ctx, DefaultConfig(), slot1, date(1, 15))
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+	})
+	t.Run("Issuance", func(t *testing.T) {
+		c := &VaccinationContract{}
+		_, err := c.checkIssue(ctx, DefaultConfig(), string(Bravo), "2050-01-05", "", pseudonym1, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+	})
+	t.Run("Swap", func(t *testing.T) {
+		rules := make(map[string]TradeRule)
+		for _, rule := range BaseTradeRules() {
+			rules[rule.Name] = rule
+		}
+		senderSlot := &VaccinationSlot{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(1, 20), Previous: slot3}}
+		recipientSlot := &VaccinationSlot{VaccinationSlotData: VaccinationSlotData{Type: Bravo, Date: date(1, 5)}}
+		trade := &Trade{SenderSlot: senderSlot, RecipientSlot: recipientSlot, Config: DefaultConfig()}
+		assert.ErrorIs(t, rules["recipient-interval"].Check(ctx, trade), contracterr.RuleViolation)
+		assert.Nil(t, rules["sender-interval"].Check(ctx, trade))
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test Series">
+func TestSeries(t *testing.T) {
+	date := func(month time.Month, day int) VaccinationDate {
+		return VaccinationDate(time.Date(2050, month, day, 0, 0, 0, 0, time.UTC))
+	}
+	dose := func(tokenId string, n int, month time.Month, day int, status SlotStatus) VaccinationSlot {
+		return VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(month, day), Status: status, SeriesId: slot1, Dose: n},
+			TokenId:             tokenId,
+			Owner:               pseudonym1,
+			Version:             SchemaVersion,
+		}
+	}
+	series := Series{Id: slot1, Patient: pseudonym1, Product: Alpha, PlannedDoses: 2, Version: SchemaVersion}
+
+	t.Run("Start", func(t *testing.T) {
+		ctx, ms, gen := setupTestIssueSlot1()
+		c := &VaccinationContract{IdGenerator: gen}
+		_, err := c.IssueSlot(ctx, "delta", "2000-01-01", patient1, "")
 		assert.Nil(t, err)
-		offers := make([]TradeOffer, 0)
-		err = json.Unmarshal([]byte(offersStr), &offers)
+
+		started := Series{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, seriesPrefix), &started))
+		assert.Equal(t, Series{Id: slot1, Patient: pseudonym1, Product: Delta, PlannedDoses: defaultDoses, Version: SchemaVersion}, started)
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, ".")), &vs))
+		assert.Equal(t, slot1, vs.SeriesId)
+		assert.Equal(t, 1, vs.Dose)
+	})
+	t.Run("Next dose", func(t *testing.T) {
+		ctx, _ := setupTestSeries(&series, dose(slot1, 1, 1, 1, StatusAdministered))
+		seriesId, n, err := nextDose(ctx, pseudonym1, slot1, slot3, newPendingMoves())
 		assert.Nil(t, err)
-		assert.Equal(t, 2, len(offers))
+		assert.Equal(t, slot1, seriesId)
+		assert.Equal(t, 2, n)
 	})
-	t.Run("Patient3 0 slot", func(t *testing.T) {
-		ctx, _ := setupTestListOffers(patient3)
+	t.Run("Already issued", func(t *testing.T) {
+		ctx, _ := setupTestSeries(&series, dose(slot1, 1, 1, 1, StatusAdministered), dose(slot2, 2, 1, 20, StatusIssued))
+		_, _, err := nextDose(ctx, pseudonym1, slot1, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.Conflict)
+
+		ctx, _ = setupTestSeries(&series, dose(slot1, 1, 1, 1, StatusAdministered))
+		pending := newPendingMoves()
+		pending.add(&VaccinationSlot{VaccinationSlotData: VaccinationSlotData{Date: date(1, 20), SeriesId: slot1, Dose: 2}, Owner: pseudonym1})
+		_, _, err = nextDose(ctx, pseudonym1, slot1, slot3, pending)
+		assert.ErrorIs(t, err, contracterr.Conflict)
+	})
+	t.Run("Complete", func(t *testing.T) {
+		ctx, _ := setupTestSeries(&series, dose(slot1, 1, 1, 1, StatusAdministered), dose(slot2, 2, 1, 20, StatusAdministered))
+		_, _, err := nextDose(ctx, pseudonym1, slot2, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+	})
+	t.Run("Previous not administered", func(t *testing.T) {
+		ctx, _ := setupTestSeries(&series, dose(slot1, 1, 1, 1, StatusIssued))
+		_, _, err := nextDose(ctx, pseudonym1, slot1, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+
+		pending := newPendingMoves()
+		pending.administered[slot1] = true
+		_, n, err := nextDose(ctx, pseudonym1, slot1, slot3, pending)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+	})
+	t.Run("Closed", func(t *testing.T) {
+		closed := series
+		closed.Closed = true
+		ctx, _ := setupTestSeries(&closed, dose(slot1, 1, 1, 1, StatusAdministered))
+		_, _, err := nextDose(ctx, pseudonym1, slot1, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+	})
+	t.Run("Other patient", func(t *testing.T) {
+		ctx, _ := setupTestSeries(nil, dose(slot1, 1, 1, 1, StatusAdministered))
+		_, _, err := nextDose(ctx, pseudonym1, slot1, slot3, newPendingMoves())
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+	})
+	t.Run("Legacy", func(t *testing.T) {
+		legacy := dose(slot1, 0, 1, 1, StatusAdministered)
+		legacy.SeriesId = ""
+		ctx, _ := setupTestSeries(&series, legacy)
+		seriesId, n, err := nextDose(ctx, pseudonym1, slot1, slot3, newPendingMoves())
+		assert.Nil(t, err)
+		assert.Empty(t, seriesId)
+		assert.Zero(t, n)
+	})
+	t.Run("Restart after missed interval", func(t *testing.T) {
+		ctx, _ := setupTestSeries(&series, dose(slot1, 1, 1, 1, StatusAdministered))
+		seriesId, n, err := nextDose(ctx, pseudonym1, "", slot3, newPendingMoves())
+		assert.Nil(t, err)
+		assert.Equal(t, slot3, seriesId)
+		assert.Equal(t, 1, n)
+	})
+	t.Run("GetSeries", func(t *testing.T) {
+		ctx, _ := setupTestSeries(&series, dose(slot2, 2, 1, 20, StatusIssued), dose(slot1, 1, 1, 1, StatusAdministered))
 		c := &VaccinationContract{}
-		offersStr, err := c.ListOffers(ctx)
+		result, err := c.GetSeries(ctx, pseudonym1)
 		assert.Nil(t, err)
-		offers := make([]TradeOffer, 0)
-		err = json.Unmarshal([]byte(offersStr), &offers)
+
+		statuses := make([]SeriesStatus, 0)
+		assert.Nil(t, json.Unmarshal([]byte(result), &statuses))
+		assert.Len(t, statuses, 1)
+		assert.Equal(t, SeriesInProgress, statuses[0].Status)
+		assert.Equal(t, 1, statuses[0].Administered)
+		assert.Equal(t, []string{slot1, slot2}, []string{statuses[0].Doses[0].TokenId, statuses[0].Doses[1].TokenId})
+	})
+	t.Run("Cancel first dose", func(t *testing.T) {
+		first := dose(slot1, 1, 1, 1, StatusIssued)
+		ctx, ms := setupTestSeries(&series, first)
+		key := strings.Join([]string{seriesPrefix, pseudonym1, slot1}, ".")
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+		assert.Nil(t, closeSeries(ctx, &first))
+
+		closed := Series{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, key), &closed))
+		assert.True(t, closed.Closed)
+
+		second := dose(slot2, 2, 1, 20, StatusIssued)
+		ctx, ms = setupTestSeries(&series, second)
+		assert.Nil(t, closeSeries(ctx, &second))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+
+		ctx, _ = setupTestSeries(&closed)
+		c := &VaccinationContract{}
+		result, err := c.GetSeries(ctx, pseudonym1)
 		assert.Nil(t, err)
-		assert.Equal(t, 0, len(offers))
+		statuses := make([]SeriesStatus, 0)
+		assert.Nil(t, json.Unmarshal([]byte(result), &statuses))
+		assert.Len(t, statuses, 1)
+		assert.Equal(t, SeriesClosed, statuses[0].Status)
 	})
 }
 
-func setupTestListOffers(patient string) (*MockContext, *MockStub) {
+// setupTestSeries mocks series of pseudonym1, or no series if it's nil, and the slots in the balance of pseudonym1.
+// The client is patient1.
+func setupTestSeries(series *Series, slots ...VaccinationSlot) (*MockContext, *MockStub) {
 	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+
+	balance := &MockIterator{}
+	for _, vs := range slots {
+		vsb, _ := json.Marshal(&vs)
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		balance.queries = append(balance.queries, queryresult.KV{Key: vs.TokenId, Value: []byte(vs.TokenId)})
+	}
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(balance, nil)
 
-	patient164 := base64.StdEncoding.EncodeToString([]byte(patient1))
-	patient264 := base64.StdEncoding.EncodeToString([]byte(patient2))
-	patient364 := base64.StdEncoding.EncodeToString([]byte(patient3))
-
+	list := &MockIterator{}
 	{
-		tOffer1 := &TradeOffer{
-			Uuid:          offer1,
-			Sender:        patient1,
-			SenderItem:    slot1,
-			Recipient:     patient2,
-			RecipientItem: slot2,
-		}
-		offer1Bytes, _ := json.Marshal(tOffer1)
-		tOffer2 := &TradeOffer{
-			Uuid:          offer2,
-			Sender:        patient2,
-			SenderItem:    slot3,
-			Recipient:     patient1,
-			RecipientItem: slot1,
+		key := strings.Join([]string{seriesPrefix, pseudonym1, slot1}, ".")
+		ms.On(createCompositeKey, seriesPrefix, []string{pseudonym1, slot1}).Return(key, nil)
+		if series != nil {
+			seriesBytes, _ := json.Marshal(series)
+			ms.On(getState, key).Return(seriesBytes, nil)
+			list.queries = append(list.queries, queryresult.KV{Key: key, Value: seriesBytes})
+		} else {
+			ms.On(getState, key).Return([]byte{}, nil)
 		}
-		offer2Bytes, _ := json.Marshal(tOffer2)
-		it := &MockIterator{queries: []queryresult.KV{
-			{
-				Value: offer1Bytes,
-			},
-			{
-				Value: offer2Bytes,
-			},
-		}}
-		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{patient164}).Return(it, nil)
-		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{patient264}).Return(it, nil)
-	}
-	{
-		it := &MockIterator{}
-		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{patient364}).Return(it, nil)
 	}
+	ms.On(getStateByPartialCompositeKey, seriesPrefix, []string{pseudonym1}).Return(list, nil)
 
-	patient64 := base64.StdEncoding.EncodeToString([]byte(patient))
 	mci := &MockClientIdentity{}
-	mci.On(getID).Return(patient64, nil)
-
-	mc := &MockContext{}
-	mc.On(getStub).Return(ms)
-	mc.On(getClientIdentity).Return(mci)
+	mockRole(ms, mci, defaultPatientMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(patient1)), nil)
 
-	return mc, ms
+	ctx := &MockContext{}
+	ctx.On(getStub).Return(ms)
+	ctx.On(getClientIdentity).Return(mci)
+	return ctx, ms
 }
 
-//<editor-fold>
+//</editor-fold>
+
+//<editor-fold desc="Test GetEligibility">
+func TestGetEligibility(t *testing.T) {
+	dose := func(tokenId string, vt VaccinationType, n int, day int, status SlotStatus) VaccinationSlot {
+		return VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{Type: vt, Date: VaccinationDate(time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)), Status: status, SeriesId: slot1, Dose: n},
+			TokenId:             tokenId,
+			Owner:               pseudonym1,
+			Version:             SchemaVersion,
+		}
+	}
+	series := Series{Id: slot1, Patient: pseudonym1, Product: Alpha, PlannedDoses: 2, Version: SchemaVersion}
+	getEligibility := func(t *testing.T, now string, slots ...VaccinationSlot) Eligibility {
+		ctx, ms := setupTestSeries(&series, slots...)
+		mockTxTime(ms, now)
+		c := &VaccinationContract{}
+		result, err := c.GetEligibility(ctx, pseudonym1, string(Alpha))
+		assert.Nil(t, err)
+		eligibility := Eligibility{}
+		assert.Nil(t, json.Unmarshal([]byte(result), &eligibility))
+		return eligibility
+	}
+
+	t.Run("First dose", func(t *testing.T) {
+		eligibility := getEligibility(t, "2050-01-05")
+		assert.True(t, eligibility.Eligible)
+		assert.Equal(t, "2050-01-05", eligibility.Earliest.String())
+		assert.Nil(t, eligibility.Latest)
+		assert.Empty(t, eligibility.Previous)
+	})
+	t.Run("Next dose", func(t *testing.T) {
+		eligibility := getEligibility(t, "2050-01-05", dose(slot1, Alpha, 1, 1, StatusAdministered))
+		assert.True(t, eligibility.Eligible)
+		assert.Equal(t, "2050-01-15", eligibility.Earliest.String())
+		assert.Equal(t, "2050-01-31", eligibility.Latest.String())
+		assert.Equal(t, slot1, eligibility.Previous)
+		assert.Equal(t, 2, eligibility.Dose)
+
+		eligibility = getEligibility(t, "2050-01-20", dose(slot1, Alpha, 1, 1, StatusAdministered))
+		assert.Equal(t, "2050-01-20", eligibility.Earliest.String())
+	})
+	t.Run("Missed interval", func(t *testing.T) {
+		eligibility := getEligibility(t, "2050-01-31", dose(slot1, Alpha, 1, 1, StatusAdministered))
+		assert.True(t, eligibility.Eligible)
+		assert.Empty(t, eligibility.Missed)
+
+		eligibility = getEligibility(t, "2050-02-10", dose(slot1, Alpha, 1, 1, StatusAdministered))
+		assert.True(t, eligibility.Eligible)
+		assert.Equal(t, "2050-02-10", eligibility.Earliest.String())
+		assert.Nil(t, eligibility.Latest)
+		assert.Empty(t, eligibility.Previous)
+		assert.Empty(t, eligibility.SeriesId)
+		assert.Equal(t, slot1, eligibility.Missed)
+	})
+	t.Run("Legacy dose", func(t *testing.T) {
+		legacy := dose(slot3, Alpha, 0, 1, StatusAdministered)
+		legacy.SeriesId = ""
+		eligibility := getEligibility(t, "2050-01-05", legacy)
+		assert.True(t, eligibility.Eligible)
+		assert.Equal(t, "2050-01-15", eligibility.Earliest.String())
+		assert.Equal(t, slot3, eligibility.Previous)
+		assert.Empty(t, eligibility.SeriesId)
+
+		eligibility = getEligibility(t, "2050-02-10", legacy)
+		assert.True(t, eligibility.Eligible)
+		assert.Equal(t, "2050-02-10", eligibility.Earliest.String())
+		assert.Empty(t, eligibility.Previous)
+		assert.Equal(t, slot3, eligibility.Missed)
+	})
+	t.Run("Complete series", func(t *testing.T) {
+		eligibility := getEligibility(t, "2050-02-10", dose(slot1, Alpha, 1, 1, StatusAdministered), dose(slot2, Alpha, 2, 20, StatusAdministered))
+		assert.True(t, eligibility.Eligible)
+		assert.Equal(t, "2050-02-10", eligibility.Earliest.String())
+		assert.Empty(t, eligibility.Previous)
+	})
+	t.Run("Live slot", func(t *testing.T) {
+		eligibility := getEligibility(t, "2050-01-05", dose(slot1, Alpha, 1, 1, StatusAdministered), dose(slot2, Alpha, 2, 20, StatusIssued))
+		assert.False(t, eligibility.Eligible)
+		assert.Contains(t, eligibility.Reason, slot2)
+
+		eligibility = getEligibility(t, "2050-01-05", dose(slot1, Alpha, 1, 1, StatusAdministered), dose(slot2, Bravo, 2, 20, StatusIssued))
+		assert.False(t, eligibility.Eligible)
+		assert.Contains(t, eligibility.Reason, "already issued")
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test GetVaccinationRecord">
+func TestGetVaccinationRecord(t *testing.T) {
+	administered := func(tokenId string, vt VaccinationType, seriesId string, n int, day int) VaccinationSlot {
+		return VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{
+				Type:     vt,
+				Date:     VaccinationDate(time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)),
+				Status:   StatusAdministered,
+				SeriesId: seriesId,
+				Dose:     n,
+				StatusHistory: []StatusChange{{
+					From:      StatusIssued,
+					To:        StatusAdministered,
+					Actor:     pseudonymOf(doctor1),
+					Timestamp: time.Date(2050, 1, day, 10, 0, 0, 0, time.UTC),
+				}},
+			},
+			TokenId: tokenId,
+			Owner:   pseudonym1,
+			Version: SchemaVersion,
+		}
+	}
+	slots := []VaccinationSlot{
+		administered(slot2, Alpha, slot1, 2, 20),
+		administered(slot3, Bravo, "", 0, 5),
+		administered(slot1, Alpha, slot1, 1, 1),
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: VaccinationDate(time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC)), Status: StatusIssued}, TokenId: slot4, Owner: pseudonym1, Version: SchemaVersion},
+	}
+
+	t.Run("Patient", func(t *testing.T) {
+		ctx, _ := setupTestRecord(patient1, defaultPatientMSP, nil, slots...)
+		c := &VaccinationContract{}
+		result, err := c.GetVaccinationRecord(ctx, pseudonym1)
+		assert.Nil(t, err)
+
+		record := VaccinationRecord{}
+		assert.Nil(t, json.Unmarshal([]byte(result), &record))
+		assert.Len(t, record.Groups, 2)
+		assert.Equal(t, slot1, record.Groups[0].SeriesId)
+		assert.Equal(t, 2, record.Groups[0].PlannedDoses)
+		assert.Equal(t, []string{slot1, slot2}, []string{record.Groups[0].Doses[0].TokenId, record.Groups[0].Doses[1].TokenId})
+		assert.Equal(t, pseudonymOf(doctor1), record.Groups[0].Doses[0].AdministeredBy)
+		assert.Equal(t, time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC), record.Groups[0].Doses[0].AdministeredAt)
+		assert.Equal(t, Bravo, record.Groups[1].Type)
+		assert.Empty(t, record.Groups[1].SeriesId)
+		assert.Len(t, record.Groups[1].Doses, 1)
+	})
+	t.Run("Medical station", func(t *testing.T) {
+		ctx, _ := setupTestRecord(doctor1, defaultDoctorMSP, nil, slots...)
+		c := &VaccinationContract{}
+		_, err := c.GetVaccinationRecord(ctx, pseudonym1)
+		assert.Nil(t, err)
+	})
+	t.Run("Without consent", func(t *testing.T) {
+		ctx, _ := setupTestRecord(patient2, defaultPatientMSP, nil, slots...)
+		c := &VaccinationContract{}
+		_, err := c.GetVaccinationRecord(ctx, pseudonym1)
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+	})
+	t.Run("Consent", func(t *testing.T) {
+		consent := &Consent{Patient: pseudonym1, Verifier: pseudonym2, Scope: ConsentRecord, Until: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)}
+		ctx, _ := setupTestRecord(patient2, defaultPatientMSP, consent, slots...)
+		c := &VaccinationContract{}
+		_, err := c.GetVaccinationRecord(ctx, pseudonym1)
+		assert.Nil(t, err)
+
+		consent.Until = time.Date(2049, 12, 1, 0, 0, 0, 0, time.UTC)
+		ctx, _ = setupTestRecord(patient2, defaultPatientMSP, consent, slots...)
+		_, err = c.GetVaccinationRecord(ctx, pseudonym1)
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+	})
+	t.Run("Slot queries", func(t *testing.T) {
+		consent := &Consent{Patient: pseudonym1, Verifier: pseudonym2, Scope: ConsentRecord, Until: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)}
+		c := &VaccinationContract{}
+
+		ctx, _ := setupTestRecord(patient2, defaultPatientMSP, consent, slots...)
+		_, err := c.GetSlots(ctx, pseudonym1)
+		assert.Nil(t, err)
+
+		ctx, _ = setupTestRecord(patient3, defaultPatientMSP, consent, slots...)
+		_, err = c.GetSlots(ctx, pseudonym1)
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+		_, err = c.GetSlotsByStatus(ctx, pseudonym1, string(StatusAdministered))
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+		_, err = c.GetSeries(ctx, pseudonym1)
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+		_, err = c.GetEligibility(ctx, pseudonym1, string(Alpha))
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+	})
+	t.Run("GrantConsent", func(t *testing.T) {
+		ctx, ms := setupTestRecord(patient1, defaultPatientMSP, nil)
+		c := &VaccinationContract{}
+		assert.Nil(t, c.GrantConsent(ctx, pseudonym2, ConsentRecord, 30))
+
+		consent := Consent{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{consentPrefix, pseudonym1, pseudonym2, ConsentRecord}, ".")), &consent))
+		assert.Equal(t, pseudonym1, consent.Patient)
+		assert.Equal(t, time.Date(2050, 1, 31, 0, 0, 0, 0, time.UTC), consent.Until)
+
+		assert.ErrorIs(t, c.GrantConsent(ctx, pseudonym2, "everything", 30), contracterr.InvalidArgument)
+		assert.ErrorIs(t, c.GrantConsent(ctx, pseudonym2, ConsentRecord, 0), contracterr.InvalidArgument)
+	})
+}
+
+// setupTestRecord mocks the series slot1 and the slots of pseudonym1 for the client id in mspid on 2050-01-01.
+// Checks of IsVaccinated are stored under verificationPrefix with the id tx1.
+// Consent is the consent of pseudonym1 to pseudonym2, none if it's nil.
+func setupTestRecord(clientId, mspid string, consent *Consent, slots ...VaccinationSlot) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockTxTime(ms, "2050-01-01")
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+
+	balance := &MockIterator{}
+	for _, vs := range slots {
+		vsb, _ := json.Marshal(&vs)
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		balance.queries = append(balance.queries, queryresult.KV{Key: vs.TokenId, Value: []byte(vs.TokenId)})
+	}
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(balance, nil)
+
+	series := &Series{Id: slot1, Patient: pseudonym1, Product: Alpha, PlannedDoses: 2, Version: SchemaVersion}
+	seriesBytes, _ := json.Marshal(series)
+	seriesKey := strings.Join([]string{seriesPrefix, pseudonym1, slot1}, ".")
+	ms.On(createCompositeKey, seriesPrefix, []string{pseudonym1, slot1}).Return(seriesKey, nil)
+	ms.On(getState, seriesKey).Return(seriesBytes, nil)
+
+	for _, verifier := range []string{pseudonym2, pseudonym3} {
+		for _, scope := range []string{ConsentRecord, ConsentVaccinated} {
+			consentBytes := []byte{}
+			if consent != nil && consent.Verifier == verifier && consent.Scope == scope {
+				consentBytes, _ = json.Marshal(consent)
+			}
+			key := strings.Join([]string{consentPrefix, pseudonym1, verifier, scope}, ".")
+			ms.On(createCompositeKey, consentPrefix, []string{pseudonym1, verifier, scope}).Return(key, nil)
+			ms.On(getState, key).Return(consentBytes, nil)
+			ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+			ms.On(delState, key).Return(nil)
+		}
+	}
+	ms.On(createCompositeKey, verificationPrefix, mock.Anything).Return(verificationPrefix, nil)
+	ms.On(putState, verificationPrefix, mock.AnythingOfType("[]uint8")).Return(nil)
+	ms.On(getTxID).Return("tx1")
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, mspid)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(clientId)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test IsVaccinated">
+func TestIsVaccinated(t *testing.T) {
+	administered := func(tokenId string, vt VaccinationType, seriesId string, n int, day int) VaccinationSlot {
+		return VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{Type: vt, Date: VaccinationDate(time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)), Status: StatusAdministered, SeriesId: seriesId, Dose: n},
+			TokenId:             tokenId,
+			Owner:               pseudonym1,
+			Version:             SchemaVersion,
+		}
+	}
+	complete := []VaccinationSlot{administered(slot1, Alpha, slot1, 1, 1), administered(slot2, Alpha, slot1, 2, 20)}
+	consent := &Consent{Patient: pseudonym1, Verifier: pseudonym2, Scope: ConsentVaccinated, Until: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)}
+	c := &VaccinationContract{}
+
+	t.Run("Complete series", func(t *testing.T) {
+		ctx, ms := setupTestRecord(patient2, defaultPatientMSP, consent, complete...)
+		vaccinated, err := c.IsVaccinated(ctx, pseudonym1, string(Alpha), "2050-01-25")
+		assert.Nil(t, err)
+		assert.True(t, vaccinated)
+
+		verificationBytes := lastPutState(ms, verificationPrefix)
+		verification := Verification{}
+		assert.Nil(t, json.Unmarshal(verificationBytes, &verification))
+		assert.Equal(t, pseudonym2, verification.Verifier)
+		assert.Equal(t, "2050-01-25", verification.AsOf.String())
+		assert.NotContains(t, string(verificationBytes), "result")
+		ms.AssertCalled(t, createCompositeKey, verificationPrefix, []string{pseudonym1, "2050-01-01T00:00:00.000000000Z", "tx1"})
+	})
+	t.Run("Before the last dose", func(t *testing.T) {
+		ctx, _ := setupTestRecord(patient2, defaultPatientMSP, consent, complete...)
+		vaccinated, err := c.IsVaccinated(ctx, pseudonym1, string(Alpha), "2050-01-10")
+		assert.Nil(t, err)
+		assert.False(t, vaccinated)
+	})
+	t.Run("Other vaccine", func(t *testing.T) {
+		ctx, _ := setupTestRecord(patient2, defaultPatientMSP, consent, complete...)
+		vaccinated, err := c.IsVaccinated(ctx, pseudonym1, string(Bravo), "2050-01-25")
+		assert.Nil(t, err)
+		assert.False(t, vaccinated)
+	})
+	t.Run("Legacy dose", func(t *testing.T) {
+		ctx, _ := setupTestRecord(patient2, defaultPatientMSP, consent, administered(slot3, Bravo, "", 0, 5))
+		vaccinated, err := c.IsVaccinated(ctx, pseudonym1, string(Bravo), "2050-01-25")
+		assert.Nil(t, err)
+		assert.True(t, vaccinated)
+	})
+	t.Run("Without consent", func(t *testing.T) {
+		record := *consent
+		record.Scope = ConsentRecord
+		ctx, ms := setupTestRecord(patient2, defaultPatientMSP, &record, complete...)
+		_, err := c.IsVaccinated(ctx, pseudonym1, string(Alpha), "2050-01-25")
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+		ms.AssertNotCalled(t, putState, verificationPrefix, mock.Anything)
+	})
+	t.Run("RevokeConsent", func(t *testing.T) {
+		ctx, ms := setupTestRecord(patient1, defaultPatientMSP, consent)
+		assert.Nil(t, c.RevokeConsent(ctx, pseudonym2, ConsentVaccinated))
+		ms.AssertCalled(t, delState, strings.Join([]string{consentPrefix, pseudonym1, pseudonym2, ConsentVaccinated}, "."))
+		assert.ErrorIs(t, c.RevokeConsent(ctx, pseudonym2, ConsentRecord), contracterr.NotFound)
+	})
+	t.Run("GetVerifications", func(t *testing.T) {
+		verification := &Verification{Patient: pseudonym1, Verifier: pseudonym2, Type: Alpha, Version: SchemaVersion}
+		verificationBytes, _ := json.Marshal(verification)
+
+		ctx, ms := setupTestRecord(patient1, defaultPatientMSP, nil)
+		ms.On(getStateByPartialCompositeKey, verificationPrefix, []string{pseudonym1}).Return(&MockIterator{queries: []queryresult.KV{{Key: verificationPrefix, Value: verificationBytes}}}, nil)
+		result, err := c.GetVerifications(ctx, pseudonym1)
+		assert.Nil(t, err)
+		verifications := make([]Verification, 0)
+		assert.Nil(t, json.Unmarshal([]byte(result), &verifications))
+		assert.Len(t, verifications, 1)
+
+		ctx, _ = setupTestRecord(patient2, defaultPatientMSP, consent)
+		_, err = c.GetVerifications(ctx, pseudonym1)
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test TradePolicy">
+func TestTradePolicy(t *testing.T) {
+	date := func(day int) VaccinationDate {
+		return VaccinationDate(time.Date(2050, 2, day, 0, 0, 0, 0, time.UTC))
+	}
+	vs1 := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: date(1), Site: "north"},
+		TokenId:             slot1,
+		Owner:               pseudonym1,
+	}
+	vs2 := VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{Type: Bravo, Date: date(2), Site: "south"},
+		TokenId:             slot2,
+		Owner:               pseudonym2,
+	}
+	trade := &Trade{
+		Offer:         TradeOffer{Uuid: offer1, Sender: pseudonym2, SenderItem: slot2, Recipient: pseudonym1, RecipientItem: slot1},
+		Recipient:     pseudonym1,
+		SenderSlot:    &vs2,
+		RecipientSlot: &vs1,
+		Now:           time.Date(2050, 1, 31, 12, 0, 0, 0, time.UTC),
+	}
+	names := func(rules []TradeRule) []string {
+		result := make([]string, 0)
+		for _, rule := range rules {
+			result = append(result, rule.Name)
+		}
+		return result
+	}
+
+	t.Run("Injected rules", func(t *testing.T) {
+		recorded := false
+		policy := TradeRules{{
+			Name: "closed",
+			Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+				return contracterr.New(contracterr.RuleViolation, "trading is closed")
+			},
+			Record: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+				recorded = true
+				return nil
+			},
+		}}
+
+		ctx, ms, gen, _ := setupTestAcceptOffer1(vs1, vs2)
+		c := &VaccinationContract{IdGenerator: gen, TradePolicy: policy}
+		err := c.AcceptOffer(ctx, offer1)
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+		assert.False(t, recorded)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+
+		resultJSON, err := c.CheckOffer(ctx, offer1)
+		assert.Nil(t, err)
+		result := OfferCheck{}
+		assert.Nil(t, json.Unmarshal([]byte(resultJSON), &result))
+		assert.Len(t, result.Violations, 1)
+		assert.Equal(t, "closed", result.Violations[0].Rule)
+	})
+	t.Run("Record", func(t *testing.T) {
+		recorded := false
+		policy := TradeRules{{
+			Name:  "open",
+			Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error { return nil },
+			Record: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+				recorded = true
+				return nil
+			},
+		}}
+
+		ctx, _, gen, _ := setupTestAcceptOffer1(vs1, vs2)
+		c := &VaccinationContract{IdGenerator: gen, TradePolicy: policy}
+		assert.Nil(t, c.AcceptOffer(ctx, offer1))
+		assert.True(t, recorded)
+	})
+	t.Run("Config", func(t *testing.T) {
+		policy := &ConfigTradePolicy{}
+		base := names(BaseTradeRules())
+		assert.Equal(t, base, names(policy.Rules(DefaultConfig())))
+
+		cfg := DefaultConfig()
+		cfg.Trade = TradeConfig{SameSite: true, SameType: true, MinNoticeHours: 48, MaxTradesPerMonth: 2}
+		expected := append(base, "same-site", "same-type", "min-notice", "max-trades-per-month")
+		assert.Equal(t, expected, names(policy.Rules(cfg)))
+
+		cfg.Trade.MinNoticeHours = -1
+		cfgBytes, _ := json.Marshal(cfg)
+		_, err := parseConfig(string(cfgBytes))
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+	})
+	t.Run("Same site", func(t *testing.T) {
+		assert.ErrorIs(t, SameSiteRule().Check(nil, trade), contracterr.RuleViolation)
+	})
+	t.Run("Same type", func(t *testing.T) {
+		assert.ErrorIs(t, SameTypeRule().Check(nil, trade), contracterr.RuleViolation)
+	})
+	t.Run("Min notice", func(t *testing.T) {
+		assert.Nil(t, MinNoticeRule(12*time.Hour).Check(nil, trade))
+		assert.ErrorIs(t, MinNoticeRule(48*time.Hour).Check(nil, trade), contracterr.RuleViolation)
+	})
+	t.Run("Max trades per month", func(t *testing.T) {
+		ms := &MockStub{}
+		anyBytes := mock.AnythingOfType("[]uint8")
+		for owner, count := range map[string]int{pseudonym1: 0, pseudonym2: 1} {
+			key := strings.Join([]string{tradeCountPrefix, owner, "2050-01"}, ".")
+			ms.On(createCompositeKey, tradeCountPrefix, []string{owner, "2050-01"}).Return(key, nil)
+			countBytes := []byte{}
+			if count > 0 {
+				countBytes, _ = json.Marshal(&TradeCount{Owner: owner, Month: "2050-01", Count: count, Version: SchemaVersion})
+			}
+			ms.On(getState, key).Return(countBytes, nil)
+			ms.On(putState, key, anyBytes).Return(nil)
+		}
+		ctx := &MockContext{}
+		ctx.On(getStub).Return(ms)
+
+		assert.Nil(t, MaxTradesPerMonthRule(2).Check(ctx, trade))
+		assert.ErrorIs(t, MaxTradesPerMonthRule(1).Check(ctx, trade), contracterr.RuleViolation)
+
+		assert.Nil(t, MaxTradesPerMonthRule(2).Record(ctx, trade))
+		count := &TradeCount{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{tradeCountPrefix, pseudonym2, "2050-01"}, ".")), count))
+		assert.Equal(t, 2, count.Count)
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{tradeCountPrefix, pseudonym1, "2050-01"}, ".")), count))
+		assert.Equal(t, 1, count.Count)
+	})
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test ListOffers">
+func TestListOffer(t *testing.T) {
+	t.Run("Patient1 2 slot", func(t *testing.T) {
+		ctx, _ := setupTestListOffers(patient1)
+		c := &VaccinationContract{}
+		offersStr, err := c.ListOffers(ctx)
+		assert.Nil(t, err)
+		offers := make([]TradeOffer, 0)
+		err = json.Unmarshal([]byte(offersStr), &offers)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(offers))
+	})
+	t.Run("Patient2 2 slot", func(t *testing.T) {
+		ctx, _ := setupTestListOffers(patient2)
+		c := &VaccinationContract{}
+		offersStr, err := c.ListOffers(ctx)
+		assert.Nil(t, err)
+		offers := make([]TradeOffer, 0)
+		err = json.Unmarshal([]byte(offersStr), &offers)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(offers))
+	})
+	t.Run("Patient3 0 slot", func(t *testing.T) {
+		ctx, _ := setupTestListOffers(patient3)
+		c := &VaccinationContract{}
+		offersStr, err := c.ListOffers(ctx)
+		assert.Nil(t, err)
+		offers := make([]TradeOffer, 0)
+		err = json.Unmarshal([]byte(offersStr), &offers)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(offers))
+	})
+}
+
+func setupTestListOffers(patient string) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	pseudonym264 := base64.StdEncoding.EncodeToString([]byte(pseudonym2))
+	pseudonym364 := base64.StdEncoding.EncodeToString([]byte(pseudonym3))
+
+	{
+		tOffer1 := &TradeOffer{
+			Uuid:          offer1,
+			Sender:        pseudonym1,
+			SenderItem:    slot1,
+			Recipient:     pseudonym2,
+			RecipientItem: slot2,
+		}
+		offer1Bytes, _ := json.Marshal(tOffer1)
+		tOffer2 := &TradeOffer{
+			Uuid:          offer2,
+			Sender:        pseudonym2,
+			SenderItem:    slot3,
+			Recipient:     pseudonym1,
+			RecipientItem: slot1,
+		}
+		offer2Bytes, _ := json.Marshal(tOffer2)
+		it := &MockIterator{queries: []queryresult.KV{
+			{
+				Value: offer1Bytes,
+			},
+			{
+				Value: offer2Bytes,
+			},
+		}}
+		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{pseudonym164}).Return(it, nil)
+		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{pseudonym264}).Return(it, nil)
+	}
+	{
+		it := &MockIterator{}
+		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{pseudonym364}).Return(it, nil)
+	}
+
+	patient64 := base64.StdEncoding.EncodeToString([]byte(patient))
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultPatientMSP)
+	mci.On(getID).Return(patient64, nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//<editor-fold>
+
+//<editor-fold desc="Test MigrateState">
+func TestMigrateState(t *testing.T) {
+	t.Run("Pages", func(t *testing.T) {
+		ctx, ms := setupTestMigrateState()
+		c := &VaccinationContract{}
+		resultStr, err := c.MigrateState(ctx, schemaVersionLegacy, 2, "")
+		assert.Nil(t, err)
+		result := MigrationResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, MigrationResult{Examined: 2, Migrated: 1, Bookmark: "nft.slot2", Done: false}, result)
+		ms.AssertCalled(t, putState, "nft.slot1", mock.AnythingOfType("[]uint8"))
+		ms.AssertNotCalled(t, putState, "nft.slot2", mock.Anything)
+		ms.AssertCalled(t, createCompositeKey, siteDatePrefix, []string{"", "2050-01-01", slot1})
+
+		ctx, ms = setupTestMigrateState()
+		resultStr, err = c.MigrateState(ctx, schemaVersionLegacy, 2, result.Bookmark)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, MigrationResult{Examined: 1, Migrated: 1, Bookmark: "offer.offer1", Done: true}, result)
+		ms.AssertNotCalled(t, putState, "nft.slot1", mock.Anything)
+
+		offerBytes := lastPutState(ms, "offer.offer1")
+		offer := TradeOffer{}
+		assert.Nil(t, json.Unmarshal(offerBytes, &offer))
+		assert.Equal(t, SchemaVersion, offer.Version)
+		assert.Equal(t, slot1, offer.SenderItem)
+	})
+	t.Run("Wrong version", func(t *testing.T) {
+		ctx, _ := setupTestMigrateState()
+		c := &VaccinationContract{}
+		_, err := c.MigrateState(ctx, SchemaVersion, 2, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestReadVaccinationSlot(t *testing.T) {
+	t.Run("Legacy", func(t *testing.T) {
+		ctx, _ := setupTestMigrateState()
+		vs, err := readVaccinationSlot(ctx, slot1)
+		assert.Nil(t, err)
+		assert.Equal(t, SchemaVersion, vs.Version)
+		assert.Equal(t, pseudonym1, vs.Owner)
+	})
+	t.Run("Legacy burned", func(t *testing.T) {
+		ctx, _ := setupTestMigrateState()
+		vs, err := readVaccinationSlot(ctx, slot2)
+		assert.Nil(t, err)
+		assert.Equal(t, StatusAdministered, vs.Status)
+	})
+	t.Run("Future", func(t *testing.T) {
+		ctx, _ := setupTestMigrateState()
+		_, err := readVaccinationSlot(ctx, slot3)
+		assert.Error(t, err)
+	})
+	t.Run("Missing", func(t *testing.T) {
+		ctx, _ := setupTestMigrateState()
+		_, err := readVaccinationSlot(ctx, slot4)
+		assert.ErrorIs(t, err, contracterr.NotFound)
+	})
+}
+
+func setupTestMigrateState() (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockSiteDate(ms)
+	mockSeries(ms)
+
+	legacySlot := []byte(`{"type":"alpha","date":"2050-01-01","tokenId":"slot1","owner":"` + pseudonym1 + `","approved":""}`)
+	currentSlot := []byte(`{"type":"alpha","date":"2050-01-02","tokenId":"slot2","owner":"` + pseudonym1 + `","approved":"","burned":true,"schemaVersion":2}`)
+	futureSlot := []byte(`{"type":"alpha","date":"2050-01-03","tokenId":"slot3","owner":"` + pseudonym1 + `","approved":"","schemaVersion":99}`)
+	legacyOffer := []byte(`{"uuid":"offer1","sender":"` + pseudonym1 + `","senderItem":"slot1","recipient":"` + pseudonym2 + `","recipientItem":"slot2"}`)
+
+	anyBytes := mock.AnythingOfType("[]uint8")
+
+	ms.On(getStateByPartialCompositeKey, configPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, vsPrefix, []string{}).Return(&MockIterator{queries: []queryresult.KV{
+		{Key: "nft.slot1", Value: legacySlot},
+		{Key: "nft.slot2", Value: currentSlot},
+	}}, nil)
+	ms.On(getStateByPartialCompositeKey, offerPrefix, []string{}).Return(&MockIterator{queries: []queryresult.KV{
+		{Key: "offer.offer1", Value: legacyOffer},
+	}}, nil)
+	ms.On(getStateByPartialCompositeKey, approvalPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, waitlistPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, requestPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, tradeCountPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, seriesPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, consentPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, verificationPrefix, []string{}).Return(&MockIterator{}, nil)
+	ms.On(splitCompositeKey, "nft.slot2").Return(vsPrefix, []string{slot2}, nil)
+	ms.On(putState, "nft.slot1", anyBytes).Return(nil)
+	ms.On(putState, "offer.offer1", anyBytes).Return(nil)
+	for slot, slotBytes := range map[string][]byte{slot1: legacySlot, slot2: currentSlot, slot3: futureSlot, slot4: {}} {
+		key := strings.Join([]string{vsPrefix, slot}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot}).Return(key, nil)
+		ms.On(getState, key).Return(slotBytes, nil)
+	}
+
+	mci := &MockClientIdentity{}
+	mockAdmin(ms, mci, defaultDoctorMSP)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test slot status">
+func TestSlotStatus(t *testing.T) {
+	t.Run("BurnToken", func(t *testing.T) {
+		ctx, ms := setupTestSlotStatus(StatusIssued)
+		c := &VaccinationContract{}
+		err := c.BurnToken(ctx, slot1)
+		assert.Nil(t, err)
+		vs := VaccinationSlot{}
+		vsb := ms.Calls[len(ms.Calls)-1].Arguments.Get(1).([]byte)
+		assert.Nil(t, json.Unmarshal(vsb, &vs))
+		assert.Equal(t, StatusAdministered, vs.Status)
+		assert.Equal(t, []StatusChange{{
+			From:      StatusIssued,
+			To:        StatusAdministered,
+			Actor:     pseudonymOf(doctor1),
+			Timestamp: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		}}, vs.StatusHistory)
+		ms.AssertNotCalled(t, delState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), slot1}, "."))
+		ms.AssertCalled(t, delState, strings.Join([]string{offerPrefix, offer1}, "."))
+	})
+	t.Run("BurnToken twice", func(t *testing.T) {
+		ctx, ms := setupTestSlotStatus(StatusAdministered)
+		c := &VaccinationContract{}
+		err := c.BurnToken(ctx, slot1)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("RevokeSlot", func(t *testing.T) {
+		ctx, ms := setupTestSlotStatus(StatusIssued)
+		c := &VaccinationContract{}
+		err := c.RevokeSlot(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), slot1}, "."))
+		for _, key := range []string{
+			strings.Join([]string{offerPrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), offer1}, "."),
+			strings.Join([]string{offerPrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym2)), offer1}, "."),
+			strings.Join([]string{offerPrefix, offer1}, "."),
+		} {
+			ms.AssertCalled(t, delState, key)
+		}
+	})
+	t.Run("MarkNoShow", func(t *testing.T) {
+		ctx, ms := setupTestSlotStatus(StatusIssued)
+		c := &VaccinationContract{}
+		err := c.MarkNoShow(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), slot1}, "."))
+		ms.AssertCalled(t, delState, strings.Join([]string{offerPrefix, offer1}, "."))
+	})
+	t.Run("MarkNoShow revoked", func(t *testing.T) {
+		ctx, ms := setupTestSlotStatus(StatusRevoked)
+		c := &VaccinationContract{}
+		err := c.MarkNoShow(ctx, slot1)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+func setupTestSlotStatus(status SlotStatus) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockTxTime(ms, "2050-01-01")
+
+	vs := &VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type:   Alpha,
+			Date:   VaccinationDate(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)),
+			Status: status,
+		},
+		TokenId: slot1,
+		Owner:   pseudonym1,
+		Version: SchemaVersion,
+	}
+	vsb, _ := json.Marshal(vs)
+
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	{
+		key := strings.Join([]string{vsPrefix, slot1}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot1}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+	}
+	{
+		key := strings.Join([]string{balancePrefix, pseudonym164, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, slot1}).Return(key, nil)
+		ms.On(delState, key).Return(nil)
+	}
+	mockSlotOffer(ms)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultDoctorMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(doctor1)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test next dose">
+func TestNextDose(t *testing.T) {
+	auto := DefaultConfig()
+	auto.Vaccines = map[VaccinationType]VaccineConfig{Alpha: {AutoNextDose: true}}
+	auto.Sites = map[string]SiteConfig{"north": {Capacity: 1}}
+
+	nextDoseEvent := func(t *testing.T, ms *MockStub) NextDoseIssued {
+		event := NextDoseIssued{}
+		for _, call := range ms.Calls {
+			if call.Method == setEvent && call.Arguments.String(0) == "NextDoseIssued" {
+				assert.Nil(t, json.Unmarshal(call.Arguments.Get(1).([]byte), &event))
+			}
+		}
+		return event
+	}
+
+	t.Run("Issued", func(t *testing.T) {
+		ctx, ms := setupTestNextDose(auto, 1, "2050-01-15")
+		c := &VaccinationContract{IdGenerator: &MockTokenIdGenerator{[]string{slot3}}}
+		err := c.BurnToken(ctx, slot1)
+		assert.Nil(t, err)
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot3}, ".")), &vs))
+		assert.Equal(t, "2050-01-16", vs.Date.String())
+		assert.Equal(t, "north", vs.Site)
+		assert.Equal(t, slot1, vs.Previous)
+		assert.Equal(t, slot1, vs.SeriesId)
+		assert.Equal(t, 2, vs.Dose)
+		assert.Equal(t, pseudonym1, vs.Owner)
+
+		event := nextDoseEvent(t, ms)
+		assert.Equal(t, slot3, event.TokenId)
+		assert.Equal(t, 2, event.Dose)
+	})
+	t.Run("Site full", func(t *testing.T) {
+		ctx, ms := setupTestNextDose(auto, 1, "2050-01-15", "2050-01-16")
+		c := &VaccinationContract{IdGenerator: &MockTokenIdGenerator{[]string{slot3}}}
+		err := c.BurnToken(ctx, slot1)
+		assert.Nil(t, err)
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot3}, ".")), &vs))
+		assert.Equal(t, "2050-01-17", vs.Date.String())
+	})
+	t.Run("No free date", func(t *testing.T) {
+		narrow := auto
+		narrow.Vaccines = map[VaccinationType]VaccineConfig{Alpha: {AutoNextDose: true, MinIntervalDays: 14, MaxIntervalDays: 15}}
+		ctx, ms := setupTestNextDose(narrow, 1, "2050-01-15", "2050-01-16")
+		c := &VaccinationContract{IdGenerator: &MockTokenIdGenerator{[]string{slot3}}}
+		err := c.BurnToken(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertNotCalled(t, putState, strings.Join([]string{vsPrefix, slot3}, "."), mock.Anything)
+		ms.AssertNotCalled(t, setEvent, "NextDoseIssued", mock.Anything)
+
+		event := map[string]interface{}{}
+		for _, call := range ms.Calls {
+			if call.Method == setEvent && call.Arguments.String(0) == "NextDoseUnscheduled" {
+				assert.Nil(t, json.Unmarshal(call.Arguments.Get(1).([]byte), &event))
+			}
+		}
+		assert.Equal(t, slot1, event["previous"])
+		assert.Equal(t, float64(2), event["dose"])
+		assert.NotContains(t, event, "date")
+		assert.NotContains(t, event, "tokenId")
+	})
+	t.Run("Complete", func(t *testing.T) {
+		ctx, ms := setupTestNextDose(auto, 2)
+		c := &VaccinationContract{IdGenerator: &MockTokenIdGenerator{[]string{slot3}}}
+		err := c.BurnToken(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertNotCalled(t, setEvent, "NextDoseIssued", mock.Anything)
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		ctx, ms := setupTestNextDose(DefaultConfig(), 1)
+		c := &VaccinationContract{IdGenerator: &MockTokenIdGenerator{[]string{slot3}}}
+		err := c.BurnToken(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertNotCalled(t, setEvent, "NextDoseIssued", mock.Anything)
+	})
+}
+
+// setupTestNextDose mocks slot1, dose of a series of 2 alpha doses of pseudonym1 at site north on 2050-01-01.
+// The site is full on the busy dates.
+func setupTestNextDose(cfg ContractConfig, dose int, busy ...string) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockTxTime(ms, "2050-01-01")
+	mockSiteDate(ms)
+	anyBytes := mock.AnythingOfType("[]uint8")
+
+	cfgBytes, _ := json.Marshal(cfg)
+	cfgKey := strings.Join([]string{configPrefix, configContract}, ".")
+	ms.On(createCompositeKey, configPrefix, []string{configContract}).Return(cfgKey, nil)
+	ms.On(getState, cfgKey).Return(cfgBytes, nil)
+
+	vs := &VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type:     Alpha,
+			Date:     VaccinationDate(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)),
+			Site:     "north",
+			Status:   StatusIssued,
+			SeriesId: slot1,
+			Dose:     dose,
+		},
+		TokenId: slot1,
+		Owner:   pseudonym1,
+		Version: SchemaVersion,
+	}
+	vsb, _ := json.Marshal(vs)
+	// A slot of another patient filling the site on the busy dates.
+	other := &VaccinationSlot{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Site: "north", Status: StatusIssued}, TokenId: slot4, Owner: pseudonym2, Version: SchemaVersion}
+	otherBytes, _ := json.Marshal(other)
+
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	for tokenId, slotBytes := range map[string][]byte{slot1: vsb, slot3: {}, slot4: otherBytes} {
+		key := strings.Join([]string{vsPrefix, tokenId}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{tokenId}).Return(key, nil)
+		ms.On(getState, key).Return(slotBytes, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
+
+		key = strings.Join([]string{balancePrefix, pseudonym164, tokenId}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, tokenId}).Return(key, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
+	}
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(&MockIterator{}, nil)
+	ms.On(getStateByPartialCompositeKey, offerPrefix, []string{pseudonym164}).Return(&MockIterator{}, nil)
+	for _, date := range busy {
+		index := &MockIterator{queries: []queryresult.KV{{Key: slot4, Value: []byte(slot4)}}}
+		ms.On(getStateByPartialCompositeKey, siteDatePrefix, []string{"north", date}).Return(index, nil)
+	}
+	ms.On(getStateByPartialCompositeKey, siteDatePrefix, mock.Anything).Return(&MockIterator{}, nil)
+
+	series := &Series{Id: slot1, Patient: pseudonym1, Product: Alpha, PlannedDoses: 2, Version: SchemaVersion}
+	seriesBytes, _ := json.Marshal(series)
+	seriesKey := strings.Join([]string{seriesPrefix, pseudonym1, slot1}, ".")
+	ms.On(createCompositeKey, seriesPrefix, []string{pseudonym1, slot1}).Return(seriesKey, nil)
+	ms.On(getState, seriesKey).Return(seriesBytes, nil)
+	ms.On(setEvent, "NextDoseIssued", anyBytes).Return(nil)
+	ms.On(setEvent, "NextDoseUnscheduled", anyBytes).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultDoctorMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(doctor1)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test CancelSlot">
+func TestCancelSlot(t *testing.T) {
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusIssued, pseudonym1, patient1, defaultPatientMSP, "2049-12-01")
+		c := &VaccinationContract{}
+		err := c.CancelSlot(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), slot1}, "."))
+		ms.AssertCalled(t, putState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(defaultPoolIdentity)), slot1}, "."), []byte(slot1))
+		ms.AssertCalled(t, setEvent, "SlotCancelled", mock.Anything)
+
+		vs := VaccinationSlot{}
+		vsb := lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, "."))
+		assert.Nil(t, json.Unmarshal(vsb, &vs))
+		assert.Equal(t, StatusCancelled, vs.Status)
+		assert.Equal(t, defaultPoolIdentity, vs.Owner)
+		assert.Empty(t, vs.Previous)
+		ms.AssertNotCalled(t, delState, waitlistPrefix)
+	})
+	t.Run("Cancel assigns from waitlist", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusIssued, pseudonym1, patient1, defaultPatientMSP, "2049-12-01", pseudonym1, pseudonym2)
+		c := &VaccinationContract{}
+		err := c.CancelSlot(ctx, slot1)
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, waitlistPrefix)
+		ms.AssertCalled(t, putState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym2)), slot1}, "."), []byte(slot1))
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, ".")), &vs))
+		assert.Equal(t, StatusIssued, vs.Status)
+		assert.Equal(t, pseudonym2, vs.Owner)
+
+		event := SlotCancelled{}
+		for _, call := range ms.Calls {
+			if call.Method == setEvent && call.Arguments.String(0) == "SlotCancelled" {
+				assert.Nil(t, json.Unmarshal(call.Arguments.Get(1).([]byte), &event))
+			}
+		}
+		assert.Equal(t, pseudonym1, event.From)
+		assert.Equal(t, pseudonym2, event.AssignedTo)
+	})
+	t.Run("Cancel deletes offers", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusIssued, pseudonym1, patient1, defaultPatientMSP, "2049-12-01")
+		c := &VaccinationContract{}
+		err := c.CancelSlot(ctx, slot1)
+		assert.Nil(t, err)
+		for _, key := range []string{
+			strings.Join([]string{offerPrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), offer1}, "."),
+			strings.Join([]string{offerPrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym2)), offer1}, "."),
+			strings.Join([]string{offerPrefix, offer1}, "."),
+		} {
+			ms.AssertCalled(t, delState, key)
+		}
+
+		offers, err := c.ListOffers(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "[]", offers)
+	})
+	t.Run("Not owner", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusIssued, pseudonym2, patient1, defaultPatientMSP, "2049-12-01")
+		c := &VaccinationContract{}
+		err := c.CancelSlot(ctx, slot1)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Past slot", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusIssued, pseudonym1, patient1, defaultPatientMSP, "2050-02-01")
+		c := &VaccinationContract{}
+		err := c.CancelSlot(ctx, slot1)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Administered", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusAdministered, pseudonym1, patient1, defaultPatientMSP, "2049-12-01")
+		c := &VaccinationContract{}
+		err := c.CancelSlot(ctx, slot1)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Reassign", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusCancelled, defaultPoolIdentity, doctor1, defaultDoctorMSP, "2049-12-01")
+		c := &VaccinationContract{}
+		err := c.ReassignSlot(ctx, slot1, patient2)
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(defaultPoolIdentity)), slot1}, "."))
+		ms.AssertCalled(t, putState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym2)), slot1}, "."), []byte(slot1))
+		ms.AssertCalled(t, setEvent, "Transfer", mock.Anything)
+
+		vs := VaccinationSlot{}
+		vsb := lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, "."))
+		assert.Nil(t, json.Unmarshal(vsb, &vs))
+		assert.Equal(t, StatusIssued, vs.Status)
+		assert.Equal(t, pseudonym2, vs.Owner)
+	})
+	t.Run("Reassign past slot", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusCancelled, defaultPoolIdentity, doctor1, defaultDoctorMSP, "2050-02-01")
+		c := &VaccinationContract{}
+		err := c.ReassignSlot(ctx, slot1, patient2)
+		assert.True(t, errors.Is(err, contracterr.Expired))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Reassign on an occupied date", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusCancelled, defaultPoolIdentity, doctor1, defaultDoctorMSP, "2049-12-01")
+		other := &VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{Type: Bravo, Date: VaccinationDate(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)), Status: StatusIssued},
+			TokenId:             slot3,
+			Owner:               pseudonym1,
+			Version:             SchemaVersion,
+		}
+		otherBytes, _ := json.Marshal(other)
+		key := strings.Join([]string{vsPrefix, slot3}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot3}).Return(key, nil)
+		ms.On(getState, key).Return(otherBytes, nil)
+		pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+		ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(&MockIterator{queries: []queryresult.KV{{Value: []byte(slot3)}}}, nil)
+
+		c := &VaccinationContract{}
+		err := c.ReassignSlot(ctx, slot1, patient1)
+		assert.ErrorIs(t, err, contracterr.Conflict)
+		assert.Contains(t, contracterr.MessageOf(err), "occupied")
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Reassign live slot", func(t *testing.T) {
+		ctx, ms := setupTestCancelSlot(StatusIssued, pseudonym1, doctor1, defaultDoctorMSP, "2049-12-01")
+		c := &VaccinationContract{}
+		err := c.ReassignSlot(ctx, slot1, patient2)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+// lastPutState returns the last value stored under key.
+func lastPutState(ms *MockStub, key string) []byte {
+	var value []byte
+	for _, call := range ms.Calls {
+		if call.Method == putState && call.Arguments.String(0) == key {
+			value = call.Arguments.Get(1).([]byte)
+		}
+	}
+	return value
+}
+
+// setupTestCancelSlot mocks slot1 of owner on 2050-01-01, the patients of waitlist are waiting for it in join order.
+func setupTestCancelSlot(status SlotStatus, owner string, sender string, mspid string, now string, waitlist ...string) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockSeries(ms)
+	mockTxTime(ms, now)
+
+	vs := &VaccinationSlot{
+		VaccinationSlotData: VaccinationSlotData{
+			Type:     Alpha,
+			Date:     VaccinationDate(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)),
+			Status:   status,
+			Previous: slot2,
+		},
+		TokenId: slot1,
+		Owner:   owner,
+		Version: SchemaVersion,
+	}
+	vsb, _ := json.Marshal(vs)
+
+	{
+		key := strings.Join([]string{vsPrefix, slot1}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot1}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+	}
+	for _, o := range []string{pseudonym1, pseudonym2, defaultPoolIdentity} {
+		o64 := base64.StdEncoding.EncodeToString([]byte(o))
+		key := strings.Join([]string{balancePrefix, o64, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{o64, slot1}).Return(key, nil)
+		ms.On(delState, key).Return(nil)
+		ms.On(putState, key, []byte(slot1)).Return(nil)
+	}
+	pseudonym264 := base64.StdEncoding.EncodeToString([]byte(pseudonym2))
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym264}).Return(&MockIterator{}, nil)
+	ms.On(setEvent, mock.Anything, mock.Anything).Return(nil)
+
+	mockSlotOffer(ms)
+
+	queries := make([]queryresult.KV, 0)
+	for i, patient := range waitlist {
+		entry := &WaitlistEntry{
+			Patient:  patient,
+			Type:     Alpha,
+			From:     VaccinationDate(time.Date(2049, 12, 1, 0, 0, 0, 0, time.UTC)),
+			To:       VaccinationDate(time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)),
+			JoinedAt: time.Date(2049, 11, i+1, 0, 0, 0, 0, time.UTC),
+			Version:  SchemaVersion,
+		}
+		entryBytes, _ := json.Marshal(entry)
+		queries = append(queries, queryresult.KV{Key: waitlistPrefix, Value: entryBytes})
+	}
+	ms.On(getStateByPartialCompositeKey, waitlistPrefix, []string{string(Alpha), ""}).Return(&MockIterator{queries: queries}, nil)
+	ms.On(createCompositeKey, waitlistPrefix, mock.Anything).Return(waitlistPrefix, nil)
+	ms.On(delState, waitlistPrefix).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, mspid)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(sender)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+// mockSlotOffer mocks offer1 of pseudonym1 trading slot1 for slot3 of pseudonym2, and the deletion of its keys.
+// The offer is only returned by the first query.
+func mockSlotOffer(ms *MockStub) {
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	pseudonym264 := base64.StdEncoding.EncodeToString([]byte(pseudonym2))
+	offerBytes, _ := json.Marshal(&TradeOffer{Uuid: offer1, Sender: pseudonym1, SenderItem: slot1, Recipient: pseudonym2, RecipientItem: slot3})
+	ms.On(getStateByPartialCompositeKey, offerPrefix, []string{pseudonym164}).Return(&MockIterator{queries: []queryresult.KV{{Value: offerBytes}}}, nil)
+	for _, attributes := range [][]string{{pseudonym164, offer1}, {pseudonym264, offer1}, {offer1}} {
+		key := strings.Join(append([]string{offerPrefix}, attributes...), ".")
+		ms.On(createCompositeKey, offerPrefix, attributes).Return(key, nil)
+		ms.On(delState, key).Return(nil)
+	}
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test Waitlist">
+func TestWaitlist(t *testing.T) {
+	t.Run("Assign first eligible", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(doctor1, defaultDoctorMSP)
+		c := &VaccinationContract{}
+		assigned, err := c.AssignFromWaitlist(ctx, slot1)
+		assert.Nil(t, err)
+		assert.Equal(t, pseudonym3, assigned)
+		ms.AssertCalled(t, delState, waitlistPrefix)
+		ms.AssertCalled(t, putState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym3)), slot1}, "."), []byte(slot1))
+		ms.AssertCalled(t, setEvent, "Transfer", mock.Anything)
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, ".")), &vs))
+		assert.Equal(t, StatusIssued, vs.Status)
+		assert.Equal(t, pseudonym3, vs.Owner)
+	})
+	t.Run("Live slot", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(doctor1, defaultDoctorMSP)
+		c := &VaccinationContract{}
+		_, err := c.AssignFromWaitlist(ctx, slot2)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Join", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(patient1, defaultPatientMSP)
+		c := &VaccinationContract{}
+		err := c.JoinWaitlist(ctx, string(Alpha), "south", "2050-01-01", "2050-02-01", "")
+		assert.Nil(t, err)
+
+		entry := WaitlistEntry{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, waitlistPrefix), &entry))
+		assert.Equal(t, pseudonym1, entry.Patient)
+		assert.Equal(t, "south", entry.Site)
+		assert.Equal(t, SchemaVersion, entry.Version)
+	})
+	t.Run("Join twice", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(patient1, defaultPatientMSP)
+		c := &VaccinationContract{}
+		err := c.JoinWaitlist(ctx, string(Alpha), "north", "2050-01-01", "2050-02-01", "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Join with wrong dates", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(patient1, defaultPatientMSP)
+		c := &VaccinationContract{}
+		err := c.JoinWaitlist(ctx, string(Alpha), "south", "2050-02-01", "2050-01-01", "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Join with previous not administered", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(patient2, defaultPatientMSP)
+		c := &VaccinationContract{}
+		err := c.JoinWaitlist(ctx, string(Alpha), "south", "2050-01-01", "2050-02-01", slot2)
+		assert.ErrorIs(t, err, contracterr.RuleViolation)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Leave", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(patient2, defaultPatientMSP)
+		c := &VaccinationContract{}
+		err := c.LeaveWaitlist(ctx, string(Alpha), "north")
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, waitlistPrefix)
+	})
+	t.Run("Unknown vaccine", func(t *testing.T) {
+		ctx, ms := setupTestWaitlist(patient2, defaultPatientMSP)
+		c := &VaccinationContract{}
+		err := c.LeaveWaitlist(ctx, "alhpa", "north")
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+		ms.AssertNotCalled(t, delState, mock.Anything)
+
+		ctx, _ = setupTestWaitlist(doctor1, defaultDoctorMSP)
+		_, err = c.GetWaitlist(ctx, "alhpa", "north")
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+	})
+}
+
+func setupTestWaitlist(sender string, mspid string) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockSeries(ms)
+	mockTxTime(ms, "2050-01-01")
+
+	slots := []*VaccinationSlot{
+		{
+			VaccinationSlotData: VaccinationSlotData{
+				Type:   Alpha,
+				Date:   VaccinationDate(time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)),
+				Site:   "north",
+				Status: StatusCancelled,
+			},
+			TokenId: slot1,
+			Owner:   defaultPoolIdentity,
+			Version: SchemaVersion,
+		},
+		{
+			VaccinationSlotData: VaccinationSlotData{
+				Type:   Alpha,
+				Date:   VaccinationDate(time.Date(2049, 11, 1, 0, 0, 0, 0, time.UTC)),
+				Status: StatusIssued,
+			},
+			TokenId: slot2,
+			Owner:   pseudonym2,
+			Version: SchemaVersion,
+		},
+	}
+	for _, vs := range slots {
+		vsb, _ := json.Marshal(vs)
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+	}
+	for _, o := range []string{pseudonym3, defaultPoolIdentity} {
+		o64 := base64.StdEncoding.EncodeToString([]byte(o))
+		key := strings.Join([]string{balancePrefix, o64, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{o64, slot1}).Return(key, nil)
+		ms.On(delState, key).Return(nil)
+		ms.On(putState, key, []byte(slot1)).Return(nil)
+	}
+	pseudonym364 := base64.StdEncoding.EncodeToString([]byte(pseudonym3))
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym364}).Return(&MockIterator{}, nil)
+
+	// The first patient doesn't accept the date, the second one misses the deadline of the previous dose.
+	entries := []struct {
+		patient, from, to, previous string
+	}{
+		{pseudonym1, "2050-02-01", "2050-03-01", ""},
+		{pseudonym2, "2050-01-01", "2050-02-01", slot2},
+		{pseudonym3, "2050-01-01", "2050-02-01", ""},
+	}
+	queries := make([]queryresult.KV, 0)
+	for i, e := range entries {
+		entry := &WaitlistEntry{
+			Patient:  e.patient,
+			Type:     Alpha,
+			Site:     "north",
+			Previous: e.previous,
+			JoinedAt: time.Date(2049, 12, i+1, 0, 0, 0, 0, time.UTC),
+			Version:  SchemaVersion,
+		}
+		_ = json.Unmarshal([]byte("\""+e.from+"\""), &entry.From)
+		_ = json.Unmarshal([]byte("\""+e.to+"\""), &entry.To)
+		entryBytes, _ := json.Marshal(entry)
+		queries = append(queries, queryresult.KV{Key: waitlistPrefix, Value: entryBytes})
+	}
+	ms.On(getStateByPartialCompositeKey, waitlistPrefix, []string{string(Alpha), "north"}).Return(&MockIterator{queries: queries}, nil)
+	ms.On(getStateByPartialCompositeKey, waitlistPrefix, []string{string(Alpha), "south"}).Return(&MockIterator{}, nil)
+	ms.On(createCompositeKey, waitlistPrefix, mock.Anything).Return(waitlistPrefix, nil)
+	ms.On(putState, waitlistPrefix, mock.AnythingOfType("[]uint8")).Return(nil)
+	ms.On(delState, waitlistPrefix).Return(nil)
+	ms.On(setEvent, mock.Anything, mock.Anything).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, mspid)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(sender)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test ExpireSlots">
+func TestExpireSlots(t *testing.T) {
+	t.Run("Expire", func(t *testing.T) {
+		ctx, ms := setupTestExpireSlots()
+		c := &VaccinationContract{}
+		resultStr, err := c.ExpireSlots(ctx, "", 10, "")
+		assert.Nil(t, err)
+		result := ExpiryResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, ExpiryResult{
+			Examined: 4,
+			Slots: []ExpiredSlot{
+				{TokenId: slot1, Owner: pseudonym1, Status: StatusNoShow},
+				{TokenId: slot3, Owner: defaultPoolIdentity, Status: StatusExpired},
+			},
+			Bookmark: "nft.slot4",
+			Done:     true,
+		}, result)
+		ms.AssertCalled(t, delState, strings.Join([]string{balancePrefix, base64.StdEncoding.EncodeToString([]byte(pseudonym1)), slot1}, "."))
+		ms.AssertCalled(t, delState, offerPrefix)
+		ms.AssertCalled(t, setEvent, "SlotsExpired", mock.Anything)
+		ms.AssertNotCalled(t, putState, "nft.slot2", mock.Anything)
+		ms.AssertNotCalled(t, putState, "nft.slot4", mock.Anything)
+	})
+	t.Run("Pages", func(t *testing.T) {
+		ctx, _ := setupTestExpireSlots()
+		c := &VaccinationContract{}
+		resultStr, err := c.ExpireSlots(ctx, "", 2, "")
+		assert.Nil(t, err)
+		result := ExpiryResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, "nft.slot2", result.Bookmark)
+		assert.False(t, result.Done)
+
+		ctx, ms := setupTestExpireSlots()
+		resultStr, err = c.ExpireSlots(ctx, "", 2, result.Bookmark)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, 2, result.Examined)
+		assert.True(t, result.Done)
+		ms.AssertNotCalled(t, putState, "nft.slot1", mock.Anything)
+	})
+	t.Run("Nothing to expire", func(t *testing.T) {
+		ctx, ms := setupTestExpireSlots()
+		c := &VaccinationContract{}
+		_, err := c.ExpireSlots(ctx, "2049-01-01", 10, "")
+		assert.Nil(t, err)
+		ms.AssertNotCalled(t, setEvent, mock.Anything, mock.Anything)
+	})
+	t.Run("Future asOf", func(t *testing.T) {
+		ctx, _ := setupTestExpireSlots()
+		c := &VaccinationContract{}
+		_, err := c.ExpireSlots(ctx, "2050-06-01", 10, "")
+		assert.Error(t, err)
+	})
+}
+
+func setupTestExpireSlots() (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockTxTime(ms, "2050-02-01")
+
+	past := VaccinationDate(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC))
+	future := VaccinationDate(time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC))
+	slots := []*VaccinationSlot{
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: past, Status: StatusIssued}, TokenId: slot1, Owner: pseudonym1},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: future, Status: StatusIssued}, TokenId: slot2, Owner: pseudonym1},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: past, Status: StatusCancelled}, TokenId: slot3, Owner: defaultPoolIdentity},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: past, Status: StatusAdministered}, TokenId: slot4, Owner: pseudonym2},
+	}
+	queries := make([]queryresult.KV, 0)
+	for _, vs := range slots {
+		vs.Version = SchemaVersion
+		vsb, _ := json.Marshal(vs)
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		queries = append(queries, queryresult.KV{Key: key, Value: vsb})
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+
+		owner64 := base64.StdEncoding.EncodeToString([]byte(vs.Owner))
+		balanceKey := strings.Join([]string{balancePrefix, owner64, vs.TokenId}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{owner64, vs.TokenId}).Return(balanceKey, nil)
+		ms.On(delState, balanceKey).Return(nil)
+	}
+	ms.On(getStateByPartialCompositeKey, vsPrefix, []string{}).Return(&MockIterator{queries: queries}, nil)
+
+	offer := TradeOffer{
+		Uuid:          offer1,
+		Sender:        pseudonym1,
+		SenderItem:    slot1,
+		Recipient:     pseudonym2,
+		RecipientItem: slot4,
+		Version:       SchemaVersion,
+	}
+	offerBytes, _ := json.Marshal(offer)
+	ms.On(getStateByPartialCompositeKey, offerPrefix, []string{base64.StdEncoding.EncodeToString([]byte(pseudonym1))}).Return(&MockIterator{queries: []queryresult.KV{{Key: offerPrefix, Value: offerBytes}}}, nil)
+	ms.On(getStateByPartialCompositeKey, offerPrefix, []string{base64.StdEncoding.EncodeToString([]byte(defaultPoolIdentity))}).Return(&MockIterator{}, nil)
+	ms.On(createCompositeKey, offerPrefix, mock.Anything).Return(offerPrefix, nil)
+	ms.On(delState, offerPrefix).Return(nil)
+	ms.On(setEvent, mock.Anything, mock.Anything).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultDoctorMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(doctor1)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test RescheduleSlot">
+func TestRescheduleSlot(t *testing.T) {
+	t.Run("Reschedule", func(t *testing.T) {
+		ctx, ms := setupTestRescheduleSlot()
+		c := &VaccinationContract{}
+		err := c.RescheduleSlot(ctx, slot1, "2050-01-15", "site closed")
+		assert.Nil(t, err)
+		ms.AssertCalled(t, delState, offerPrefix)
+		ms.AssertCalled(t, setEvent, "SlotRescheduled", mock.Anything)
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, ".")), &vs))
+		assert.Equal(t, "2050-01-15", vs.Date.String())
+		assert.Equal(t, 1, len(vs.RescheduleHistory))
+		assert.Equal(t, "2050-01-10", vs.RescheduleHistory[0].OldDate.String())
+		assert.Equal(t, "site closed", vs.RescheduleHistory[0].Reason)
+		assert.Equal(t, pseudonymOf(doctor1), vs.RescheduleHistory[0].Actor)
+	})
+	t.Run("Occupied", func(t *testing.T) {
+		ctx, ms := setupTestRescheduleSlot()
+		c := &VaccinationContract{}
+		err := c.RescheduleSlot(ctx, slot1, "2050-01-12", "site closed")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Deadline", func(t *testing.T) {
+		ctx, ms := setupTestRescheduleSlot()
+		c := &VaccinationContract{}
+		err := c.RescheduleSlot(ctx, slot1, "2050-01-25", "site closed")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Missing reason", func(t *testing.T) {
+		ctx, ms := setupTestRescheduleSlot()
+		c := &VaccinationContract{}
+		err := c.RescheduleSlot(ctx, slot1, "2050-01-15", "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+func setupTestRescheduleSlot() (*MockContext, *MockStub) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockSiteDate(ms)
+	mockSeries(ms)
+	mockTxTime(ms, "2050-01-01")
+
+	slots := []*VaccinationSlot{
+		{
+			VaccinationSlotData: VaccinationSlotData{
+				Type:     Alpha,
+				Date:     VaccinationDate(time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)),
+				Previous: slot2,
+				Status:   StatusIssued,
+			},
+			TokenId: slot1,
+			Owner:   pseudonym1,
+		},
+		{
+			VaccinationSlotData: VaccinationSlotData{
+				Type:   Alpha,
+				Date:   VaccinationDate(time.Date(2049, 12, 20, 0, 0, 0, 0, time.UTC)),
+				Status: StatusAdministered,
+			},
+			TokenId: slot2,
+			Owner:   pseudonym1,
+		},
+		{
+			VaccinationSlotData: VaccinationSlotData{
+				Type:   Bravo,
+				Date:   VaccinationDate(time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC)),
+				Status: StatusIssued,
+			},
+			TokenId: slot3,
+			Owner:   pseudonym1,
+		},
+	}
+	balance := make([]queryresult.KV, 0)
+	for _, vs := range slots {
+		vs.Version = SchemaVersion
+		vsb, _ := json.Marshal(vs)
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+		balance = append(balance, queryresult.KV{Value: []byte(vs.TokenId)})
+	}
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(&MockIterator{queries: balance}, nil)
+
+	offer := TradeOffer{
+		Uuid:          offer1,
+		Sender:        pseudonym2,
+		SenderItem:    slot4,
+		Recipient:     pseudonym1,
+		RecipientItem: slot1,
+		Version:       SchemaVersion,
+	}
+	offerBytes, _ := json.Marshal(offer)
+	ms.On(getStateByPartialCompositeKey, offerPrefix, []string{pseudonym164}).Return(&MockIterator{queries: []queryresult.KV{{Key: offerPrefix, Value: offerBytes}}}, nil)
+	ms.On(createCompositeKey, offerPrefix, mock.Anything).Return(offerPrefix, nil)
+	ms.On(delState, offerPrefix).Return(nil)
+	ms.On(setEvent, mock.Anything, mock.Anything).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultDoctorMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(doctor1)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test BulkReschedule">
+func TestBulkReschedule(t *testing.T) {
+	t.Run("Other site", func(t *testing.T) {
+		ctx, ms := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		resultStr, err := c.BulkReschedule(ctx, "north", "2050-01-10", "2050-01-10", string(StrategyOtherSite), "south", 10, "")
+		assert.Nil(t, err)
+		result := BulkRescheduleResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, 3, result.Examined)
+		assert.Equal(t, 1, result.Moved)
+		assert.Equal(t, 2, result.Failed)
+		assert.True(t, result.Done)
+		assert.Equal(t, BulkRescheduleItem{TokenId: slot1, Owner: pseudonym1, OldSite: "north", OldDate: "2050-01-10", NewSite: "south", NewDate: "2050-01-10"}, result.Items[0])
+		assert.Contains(t, result.Items[1].Error, "too late")
+		assert.Contains(t, result.Items[2].Error, "full")
+		ms.AssertCalled(t, setEvent, "SlotsRescheduled", mock.Anything)
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot1}, ".")), &vs))
+		assert.Equal(t, "south", vs.Site)
+		assert.Equal(t, "north", vs.RescheduleHistory[0].OldSite)
+		ms.AssertNotCalled(t, putState, strings.Join([]string{vsPrefix, slot3}, "."), mock.Anything)
+	})
+	t.Run("Next free day", func(t *testing.T) {
+		ctx, ms := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		resultStr, err := c.BulkReschedule(ctx, "north", "2050-01-10", "2050-01-10", string(StrategyNextFreeDay), "", 10, "")
+		assert.Nil(t, err)
+		result := BulkRescheduleResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, 2, result.Moved)
+		assert.Equal(t, "2050-01-11", result.Items[0].NewDate)
+		assert.Equal(t, "north", result.Items[0].NewSite)
+		assert.Contains(t, result.Items[1].Error, "no free day")
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot5}, ".")), &vs))
+		assert.Equal(t, "2050-01-11", vs.Date.String())
+	})
+	t.Run("Pages", func(t *testing.T) {
+		ctx, _ := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		resultStr, err := c.BulkReschedule(ctx, "north", "2050-01-10", "2050-01-10", string(StrategyOtherSite), "south", 2, "")
+		assert.Nil(t, err)
+		result := BulkRescheduleResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.False(t, result.Done)
+		assert.Equal(t, "sitedate.north.2050-01-10.slot2", result.Bookmark)
+	})
+	t.Run("Out of range entries don't fill the page", func(t *testing.T) {
+		ctx, _ := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		resultStr, err := c.BulkReschedule(ctx, "north", "2050-01-10", "2050-01-10", string(StrategyOtherSite), "south", 3, "")
+		assert.Nil(t, err)
+		result := BulkRescheduleResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, 3, result.Examined)
+		assert.True(t, result.Done)
+		assert.Equal(t, "sitedate.north.2050-01-10.slot5", result.Bookmark)
+	})
+	t.Run("All sites", func(t *testing.T) {
+		ctx, ms := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		resultStr, err := c.BulkReschedule(ctx, AllSites, "2050-01-10", "2050-01-10", string(StrategyNextFreeDay), "", 10, "")
+		assert.Nil(t, err)
+		result := BulkRescheduleResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, 4, result.Examined)
+		assert.Equal(t, 3, result.Moved)
+		assert.Equal(t, BulkRescheduleItem{TokenId: slot6, Owner: pseudonym2, OldSite: "", OldDate: "2050-01-10", NewDate: "2050-01-11"}, result.Items[0])
+
+		vs := VaccinationSlot{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, strings.Join([]string{vsPrefix, slot6}, ".")), &vs))
+		assert.Equal(t, "", vs.Site)
+		assert.Equal(t, "2050-01-11", vs.Date.String())
+	})
+	t.Run("All sites to other site", func(t *testing.T) {
+		ctx, ms := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		_, err := c.BulkReschedule(ctx, AllSites, "2050-01-10", "2050-01-10", string(StrategyOtherSite), "south", 10, "")
+		assert.True(t, errors.Is(err, contracterr.InvalidArgument))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Unknown strategy", func(t *testing.T) {
+		ctx, ms := setupTestBulkReschedule()
+		c := &VaccinationContract{}
+		_, err := c.BulkReschedule(ctx, "north", "2050-01-10", "2050-01-10", "teleport", "", 10, "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+func setupTestBulkReschedule() (*MockContext, *MockStub) {
+	cfg := DefaultConfig()
+	cfg.Sites = map[string]SiteConfig{"south": {Capacity: 1}}
+	ctx, ms := setupTestConfig(defaultDoctorMSP, "", &cfg)
+	ctx.GetClientIdentity().(*MockClientIdentity).On(getID).Return(base64.StdEncoding.EncodeToString([]byte(doctor1)), nil)
+	mockPseudonymSecret(ms)
+	mockSiteDate(ms)
+	mockSeries(ms)
+	mockTxTime(ms, "2050-01-01")
+
+	closed := VaccinationDate(time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC))
+	slots := []*VaccinationSlot{
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: closed, Site: "north", Status: StatusIssued}, TokenId: slot1, Owner: pseudonym1},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: closed, Site: "north", Status: StatusIssued, Previous: slot4}, TokenId: slot2, Owner: pseudonym2},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: VaccinationDate(time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC)), Site: "north", Status: StatusIssued}, TokenId: slot3, Owner: pseudonym1},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: VaccinationDate(time.Date(2049, 12, 1, 0, 0, 0, 0, time.UTC)), Site: "south", Status: StatusAdministered}, TokenId: slot4, Owner: pseudonym2},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: closed, Site: "north", Status: StatusIssued}, TokenId: slot5, Owner: pseudonym3},
+		{VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: closed, Status: StatusIssued}, TokenId: slot6, Owner: pseudonym2},
+	}
+	index := make([]queryresult.KV, 0)
+	all := make([]queryresult.KV, 0)
+	for _, vs := range slots {
+		vs.Version = SchemaVersion
+		vsb, _ := json.Marshal(vs)
+		key := strings.Join([]string{vsPrefix, vs.TokenId}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{vs.TokenId}).Return(key, nil)
+		ms.On(getState, key).Return(vsb, nil)
+		ms.On(putState, key, mock.AnythingOfType("[]uint8")).Return(nil)
+		entry := queryresult.KV{Key: strings.Join([]string{siteDatePrefix, vs.Site, vs.Date.String(), vs.TokenId}, "."), Value: []byte(vs.TokenId)}
+		ms.On(splitCompositeKey, entry.Key).Return(siteDatePrefix, []string{vs.Site, vs.Date.String(), vs.TokenId}, nil)
+		all = append(all, entry)
+		if vs.Site == "north" {
+			index = append(index, entry)
+		}
+
+		owner64 := base64.StdEncoding.EncodeToString([]byte(vs.Owner))
+		ms.On(getStateByPartialCompositeKey, balancePrefix, []string{owner64}).Return(&MockIterator{}, nil)
+		ms.On(getStateByPartialCompositeKey, offerPrefix, []string{owner64}).Return(&MockIterator{}, nil)
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].Key < index[j].Key })
+	sort.Slice(all, func(i, j int) bool { return all[i].Key < all[j].Key })
+	ms.On(getStateByPartialCompositeKey, siteDatePrefix, []string{"north"}).Return(&MockIterator{queries: index}, nil)
+	ms.On(getStateByPartialCompositeKey, siteDatePrefix, []string{}).Return(&MockIterator{queries: all}, nil)
+	ms.On(getStateByPartialCompositeKey, siteDatePrefix, []string{"south", "2050-01-10"}).Return(&MockIterator{}, nil)
+	ms.On(setEvent, mock.Anything, mock.Anything).Return(nil)
+
+	return ctx, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test History">
+func TestHistory(t *testing.T) {
+	t.Run("GetSlotHistory", func(t *testing.T) {
+		ctx, _ := setupTestHistory(defaultDoctorMSP)
+		c := &VaccinationContract{}
+		historyStr, err := c.GetSlotHistory(ctx, slot1)
+		assert.Nil(t, err)
+		history := make([]SlotHistoryEntry, 0)
+		assert.Nil(t, json.Unmarshal([]byte(historyStr), &history))
+		assert.Equal(t, 2, len(history))
+		assert.Equal(t, "tx1", history[0].TxId)
+		assert.Equal(t, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), history[0].Timestamp.UTC())
+		assert.Equal(t, pseudonym1, history[0].Value.Owner)
+		assert.Equal(t, SchemaVersion, history[0].Value.Version)
+		assert.Equal(t, pseudonym2, history[1].Value.Owner)
+	})
+	t.Run("GetOfferHistory", func(t *testing.T) {
+		ctx, _ := setupTestHistory(defaultDoctorMSP)
+		c := &VaccinationContract{}
+		historyStr, err := c.GetOfferHistory(ctx, offer1)
+		assert.Nil(t, err)
+		history := make([]OfferHistoryEntry, 0)
+		assert.Nil(t, json.Unmarshal([]byte(historyStr), &history))
+		assert.Equal(t, 2, len(history))
+		assert.Equal(t, "tx3", history[0].TxId)
+		assert.Equal(t, slot1, history[0].Value.SenderItem)
+		assert.True(t, history[1].IsDelete)
+		assert.Nil(t, history[1].Value)
+	})
+	t.Run("Patient", func(t *testing.T) {
+		ctx, _ := setupTestHistory(defaultPatientMSP)
+		c := &VaccinationContract{}
+		_, err := c.GetSlotHistory(ctx, slot1)
+		assert.ErrorIs(t, err, contracterr.Unauthorized)
+	})
+}
+
+func setupTestHistory(mspid string) (*MockContext, *MockStub) {
+	ms := &MockStub{}
+
+	issued := timestamppb.New(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC))
+	swapped := timestamppb.New(time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC))
+	{
+		legacySlot := []byte(`{"type":"alpha","date":"2050-02-01","tokenId":"slot1","owner":"` + pseudonym1 + `","approved":""}`)
+		slot := &VaccinationSlot{
+			VaccinationSlotData: VaccinationSlotData{Type: Alpha, Date: VaccinationDate(time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)), Status: StatusIssued},
+			TokenId:             slot1,
+			Owner:               pseudonym2,
+			Version:             SchemaVersion,
+		}
+		slotBytes, _ := json.Marshal(slot)
+		key := strings.Join([]string{vsPrefix, slot1}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot1}).Return(key, nil)
+		ms.On(getHistoryForKey, key).Return(&MockHistoryIterator{modifications: []queryresult.KeyModification{
+			{TxId: "tx2", Value: slotBytes, Timestamp: swapped},
+			{TxId: "tx1", Value: legacySlot, Timestamp: issued},
+		}}, nil)
+	}
+	{
+		offer := &TradeOffer{Uuid: offer1, Sender: pseudonym1, SenderItem: slot1, Recipient: pseudonym2, RecipientItem: slot2, Version: SchemaVersion}
+		offerBytes, _ := json.Marshal(offer)
+		key := strings.Join([]string{offerPrefix, offer1}, ".")
+		ms.On(createCompositeKey, offerPrefix, []string{offer1}).Return(key, nil)
+		// The client of the deletion has a clock behind the one of the offer, the commit order still applies.
+		skewed := timestamppb.New(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC))
+		ms.On(getHistoryForKey, key).Return(&MockHistoryIterator{modifications: []queryresult.KeyModification{
+			{TxId: "tx2", IsDelete: true, Timestamp: skewed},
+			{TxId: "tx3", Value: offerBytes, Timestamp: issued},
+		}}, nil)
+	}
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, mspid)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test IssueSlots">
+func TestIssueSlots(t *testing.T) {
+	batch := []IssueRequest{
+		{Vaccine: "alpha", Date: "2050-01-01", Patient: patient1},
+		{Vaccine: "bravo", Date: "2050-01-01", Patient: patient1},
+		{Vaccine: "macskakaja", Date: "2050-01-01", Patient: patient2},
+		{Vaccine: "alpha", Date: "2050-01-02", Patient: patient2},
+	}
+	batchBytes, _ := json.Marshal(batch)
+
+	t.Run("Partial", func(t *testing.T) {
+		ctx, ms, gen := setupTestIssueSlots(string(batchBytes))
+		c := &VaccinationContract{IdGenerator: gen}
+		resultStr, err := c.IssueSlots(ctx, true)
+		assert.Nil(t, err)
+		result := BatchIssueResult{}
+		assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+		assert.Equal(t, 2, result.Issued)
+		assert.Equal(t, 2, result.Failed)
+		assert.Equal(t, IssueResult{Index: 0, TokenId: slot1}, result.Results[0])
+		assert.Equal(t, "duplicate of entry 0", result.Results[1].Error)
+		assert.NotEmpty(t, result.Results[2].Error)
+		assert.Equal(t, IssueResult{Index: 3, TokenId: slot2}, result.Results[3])
+		ms.AssertCalled(t, putState, strings.Join([]string{vsPrefix, slot1}, "."), mock.AnythingOfType("[]uint8"))
+		ms.AssertCalled(t, putState, strings.Join([]string{vsPrefix, slot2}, "."), mock.AnythingOfType("[]uint8"))
+		ms.AssertCalled(t, setEvent, "SlotsIssued", mock.Anything)
+		ms.AssertNotCalled(t, setEvent, "Transfer", mock.Anything)
+
+		for _, p := range []struct{ pseudonym, patient string }{{pseudonym1, patient1}, {pseudonym2, patient2}} {
+			recordBytes, _ := json.Marshal(&PatientRecord{Pseudonym: p.pseudonym, Patient: p.patient})
+			ms.AssertCalled(t, putPrivateData, patientCollection, strings.Join([]string{patientPrefix, p.pseudonym}, "."), recordBytes)
+		}
+	})
+	t.Run("All or nothing", func(t *testing.T) {
+		ctx, ms, gen := setupTestIssueSlots(string(batchBytes))
+		c := &VaccinationContract{IdGenerator: gen}
+		_, err := c.IssueSlots(ctx, false)
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+		ms.AssertNotCalled(t, setEvent, mock.Anything, mock.Anything)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		ctx, _, gen := setupTestIssueSlots("[]")
+		c := &VaccinationContract{IdGenerator: gen}
+		_, err := c.IssueSlots(ctx, true)
+		assert.Error(t, err)
+	})
+	t.Run("Missing batch", func(t *testing.T) {
+		ctx, ms, gen := setupTestIssueSlots("")
+		c := &VaccinationContract{IdGenerator: gen}
+		_, err := c.IssueSlots(ctx, true)
+		assert.ErrorIs(t, err, contracterr.InvalidArgument)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+}
+
+func TestChunkIssueRequests(t *testing.T) {
+	requests := []IssueRequest{
+		{Vaccine: "alpha", Date: "2050-01-01", Patient: patient1},
+		{Vaccine: "alpha", Date: "2050-01-01", Patient: patient2},
+		{Vaccine: "alpha", Date: "2050-01-01", Patient: patient3},
+		{Vaccine: "bravo", Date: "2050-01-01", Patient: patient1},
+	}
+	chunks, err := ChunkIssueRequests(requests, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(chunks))
+
+	first := make([]IssueRequest, 0)
+	assert.Nil(t, json.Unmarshal([]byte(chunks[0]), &first))
+	assert.Equal(t, []IssueRequest{requests[0], requests[3]}, first)
+
+	_, err = ChunkIssueRequests(requests, 0)
+	assert.True(t, errors.Is(err, contracterr.InvalidArgument))
+	_, err = ChunkIssueRequests(requests, 1)
+	assert.True(t, errors.Is(err, contracterr.InvalidArgument))
+}
+
+// setupTestIssueSlots mocks the batch in the transient map, it's missing if batch is empty.
+func setupTestIssueSlots(batch string) (*MockContext, *MockStub, *MockTokenIdGenerator) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockSiteDate(ms)
+	mockSeries(ms)
+	gen := &MockTokenIdGenerator{
+		[]string{slot1, slot2, slot3},
+	}
+	transient := map[string][]byte{}
+	if len(batch) > 0 {
+		transient[transientBatch] = []byte(batch)
+	}
+	ms.On(getTransient).Return(transient, nil)
+
+	anyBytes := mock.AnythingOfType("[]uint8")
+
+	for _, p := range []string{pseudonym1, pseudonym2} {
+		key := strings.Join([]string{patientPrefix, p}, ".")
+		ms.On(createCompositeKey, patientPrefix, []string{p}).Return(key, nil)
+		ms.On(putPrivateData, patientCollection, key, anyBytes).Return(nil)
+
+		p64 := base64.StdEncoding.EncodeToString([]byte(p))
+		ms.On(getStateByPartialCompositeKey, balancePrefix, []string{p64}).Return(&MockIterator{}, nil)
+		for _, slot := range []string{slot1, slot2, slot3} {
+			key := strings.Join([]string{balancePrefix, p64, slot}, ".")
+			ms.On(createCompositeKey, balancePrefix, []string{p64, slot}).Return(key, nil)
+			ms.On(putState, key, anyBytes).Return(nil)
+		}
+	}
+	for _, slot := range []string{slot1, slot2, slot3} {
+		key := strings.Join([]string{vsPrefix, slot}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot}).Return(key, nil)
+		ms.On(getState, key).Return([]byte{}, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
+	}
+	ms.On(setEvent, mock.Anything, anyBytes).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultDoctorMSP)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms, gen
+}
+
+//</editor-fold>
+
+//<editor-fold desc="Test request ids">
+func TestRequestIds(t *testing.T) {
+	t.Run("First call", func(t *testing.T) {
+		ctx, ms, gen := setupTestRequestIds(nil)
+		c := &VaccinationContract{IdGenerator: gen}
+		tokenId, err := c.IssueSlot(ctx, "delta", "2050-01-01", patient1, "")
+		assert.Nil(t, err)
+		assert.Equal(t, slot1, tokenId)
+
+		record := RequestRecord{}
+		assert.Nil(t, json.Unmarshal(lastPutState(ms, requestPrefix), &record))
+		assert.Equal(t, RequestRecord{
+			Caller:      pseudonymOf(doctor1),
+			RequestId:   "req1",
+			Transaction: "IssueSlot",
+			ArgsHash:    argsHash([]string{"delta", "2050-01-01", pseudonym1, ""}),
+			Result:      slot1,
+			CreatedAt:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:     SchemaVersion,
+		}, record)
+	})
+	t.Run("Replay", func(t *testing.T) {
+		ctx, ms, gen := setupTestRequestIds(&RequestRecord{
+			Caller:      pseudonymOf(doctor1),
+			RequestId:   "req1",
+			Transaction: "IssueSlot",
+			ArgsHash:    argsHash([]string{"delta", "2050-01-01", pseudonym1, ""}),
+			Result:      slot2,
+			Version:     SchemaVersion,
+		})
+		c := &VaccinationContract{IdGenerator: gen}
+		tokenId, err := c.IssueSlot(ctx, "delta", "2050-01-01", patient1, "")
+		assert.Nil(t, err)
+		assert.Equal(t, slot2, tokenId)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+		ms.AssertNotCalled(t, setEvent, mock.Anything, mock.Anything)
+	})
+	t.Run("Used by another transaction", func(t *testing.T) {
+		ctx, ms, gen := setupTestRequestIds(&RequestRecord{
+			Caller:      pseudonymOf(doctor1),
+			RequestId:   "req1",
+			Transaction: "MakeOffer",
+			Result:      offer1,
+			Version:     SchemaVersion,
+		})
+		c := &VaccinationContract{IdGenerator: gen}
+		_, err := c.IssueSlot(ctx, "delta", "2050-01-01", patient1, "")
+		assert.Error(t, err)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Other arguments", func(t *testing.T) {
+		ctx, ms, gen := setupTestRequestIds(&RequestRecord{
+			Caller:      pseudonymOf(doctor1),
+			RequestId:   "req1",
+			Transaction: "IssueSlot",
+			ArgsHash:    argsHash([]string{"delta", "2050-01-01", pseudonym1, ""}),
+			Result:      slot2,
+			Version:     SchemaVersion,
+		})
+		c := &VaccinationContract{IdGenerator: gen}
+		_, err := c.IssueSlot(ctx, "delta", "2050-01-02", patient1, "")
+		assert.True(t, errors.Is(err, contracterr.Conflict))
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Replay IssueSlotAt", func(t *testing.T) {
+		ctx, ms, gen := setupTestRequestIds(&RequestRecord{
+			Caller:      pseudonymOf(doctor1),
+			RequestId:   "req1",
+			Transaction: "IssueSlotAt",
+			ArgsHash:    argsHash([]string{"delta", "2050-01-01", "north", pseudonym1, ""}),
+			Result:      slot2,
+			Version:     SchemaVersion,
+		})
+		c := &VaccinationContract{IdGenerator: gen}
+		tokenId, err := c.IssueSlotAt(ctx, "delta", "2050-01-01", "north", patient1, "")
+		assert.Nil(t, err)
+		assert.Equal(t, slot2, tokenId)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+	})
+	t.Run("Replay IssueSlots", func(t *testing.T) {
+		// The batch of setupTestRequestIds is hashed with the pseudonym of the patient.
+		batch := `[{"vaccine":"delta","date":"2050-01-01","patient":"` + pseudonym1 + `"}]`
+		ctx, ms, gen := setupTestRequestIds(&RequestRecord{
+			Caller:      pseudonymOf(doctor1),
+			RequestId:   "req1",
+			Transaction: "IssueSlots",
+			ArgsHash:    argsHash([]string{batch, "false"}),
+			Result:      `{"issued":1,"failed":0,"results":[{"index":0,"tokenId":"slot2"}]}`,
+			Version:     SchemaVersion,
+		})
+		c := &VaccinationContract{IdGenerator: gen}
+		resultStr, err := c.IssueSlots(ctx, false)
+		assert.Nil(t, err)
+		assert.Contains(t, resultStr, slot2)
+		ms.AssertNotCalled(t, putState, mock.Anything, mock.Anything)
+
+		_, err = c.IssueSlots(ctx, true)
+		assert.True(t, errors.Is(err, contracterr.Conflict))
+	})
+}
+
+func TestPurgeRequests(t *testing.T) {
+	ms := &MockStub{}
+	mockTxTime(ms, "2050-03-01")
+
+	queries := make([]queryresult.KV, 0)
+	for i, created := range []time.Time{
+		time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2050, 2, 15, 0, 0, 0, 0, time.UTC),
+	} {
+		record := &RequestRecord{Caller: pseudonym1, RequestId: fmt.Sprint(i), Transaction: "MakeOffer", CreatedAt: created, Version: SchemaVersion}
+		recordBytes, _ := json.Marshal(record)
+		queries = append(queries, queryresult.KV{Key: fmt.Sprintf("request.%d", i), Value: recordBytes})
+	}
+	ms.On(getStateByPartialCompositeKey, requestPrefix, []string{}).Return(&MockIterator{queries: queries}, nil)
+	ms.On(delState, "request.0").Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockAdmin(ms, mci, defaultDoctorMSP)
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	c := &VaccinationContract{}
+	resultStr, err := c.PurgeRequests(mc, 10, "")
+	assert.Nil(t, err)
+	result := PurgeResult{}
+	assert.Nil(t, json.Unmarshal([]byte(resultStr), &result))
+	assert.Equal(t, PurgeResult{Examined: 2, Purged: 1, Bookmark: "request.1", Done: true}, result)
+	ms.AssertNotCalled(t, delState, "request.1")
+}
+
+func setupTestRequestIds(stored *RequestRecord) (*MockContext, *MockStub, *MockTokenIdGenerator) {
+	ms := &MockStub{}
+	mockPseudonymSecret(ms)
+	mockSiteDate(ms)
+	mockSeries(ms)
+	mockTxTime(ms, "2050-01-01")
+	gen := &MockTokenIdGenerator{
+		[]string{slot1},
+	}
+
+	anyBytes := mock.AnythingOfType("[]uint8")
+
+	ms.On(getTransient).Return(map[string][]byte{
+		transientRequestId: []byte("req1"),
+		transientBatch:     []byte(`[{"vaccine":"delta","date":"2050-01-01","patient":"` + patient1 + `"}]`),
+	}, nil)
+	{
+		storedBytes := []byte{}
+		if stored != nil {
+			storedBytes, _ = json.Marshal(stored)
+		}
+		ms.On(createCompositeKey, requestPrefix, []string{pseudonymOf(doctor1), "req1"}).Return(requestPrefix, nil)
+		ms.On(getState, requestPrefix).Return(storedBytes, nil)
+		ms.On(putState, requestPrefix, anyBytes).Return(nil)
+	}
+
+	pseudonym164 := base64.StdEncoding.EncodeToString([]byte(pseudonym1))
+	ms.On(getStateByPartialCompositeKey, balancePrefix, []string{pseudonym164}).Return(&MockIterator{}, nil)
+	{
+		key := strings.Join([]string{vsPrefix, slot1}, ".")
+		ms.On(createCompositeKey, vsPrefix, []string{slot1}).Return(key, nil)
+		ms.On(getState, key).Return([]byte{}, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
+	}
+	{
+		key := strings.Join([]string{balancePrefix, pseudonym164, slot1}, ".")
+		ms.On(createCompositeKey, balancePrefix, []string{pseudonym164, slot1}).Return(key, nil)
+		ms.On(putState, key, anyBytes).Return(nil)
+	}
+	ms.On(setEvent, "Transfer", anyBytes).Return(nil)
+
+	mci := &MockClientIdentity{}
+	mockRole(ms, mci, defaultDoctorMSP)
+	mci.On(getID).Return(base64.StdEncoding.EncodeToString([]byte(doctor1)), nil)
+
+	mc := &MockContext{}
+	mc.On(getStub).Return(ms)
+	mc.On(getClientIdentity).Return(mci)
+
+	return mc, ms, gen
+}
+
+//</editor-fold>
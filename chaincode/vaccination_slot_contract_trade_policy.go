@@ -0,0 +1,301 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const tradeCountPrefix = "trades"
+
+// TradeConfig enables the optional trade rules of ConfigTradePolicy.
+// The zero value only applies BaseTradeRules.
+type TradeConfig struct {
+	// Both slots must be at the same site.
+	SameSite bool `json:"sameSite,omitempty"`
+	// Both slots must be of the same vaccine type.
+	SameType bool `json:"sameType,omitempty"`
+	// Hours before the date of either slot from which it can't be traded anymore.
+	MinNoticeHours int `json:"minNoticeHours,omitempty"`
+	// Trades accepted per patient in a calendar month.
+	MaxTradesPerMonth int `json:"maxTradesPerMonth,omitempty"`
+}
+
+func (cfg TradeConfig) validate() error {
+	if cfg.MinNoticeHours < 0 {
+		return contracterr.New(contracterr.InvalidArgument, "minNoticeHours must not be negative")
+	}
+	if cfg.MaxTradesPerMonth < 0 {
+		return contracterr.New(contracterr.InvalidArgument, "maxTradesPerMonth must not be negative")
+	}
+	return nil
+}
+
+// Trade is an offer about to be accepted by Recipient, the invoking client.
+type Trade struct {
+	Offer         TradeOffer
+	Recipient     string
+	SenderSlot    *VaccinationSlot
+	RecipientSlot *VaccinationSlot
+	Now           time.Time
+	Config        ContractConfig
+}
+
+// TradeRule is a named rule of the trades.
+//
+// Check returns a contracterr error if the trade breaks the rule.
+// Record is optional, it's called after the trade is accepted, e.g. to count the trades.
+type TradeRule struct {
+	Name   string
+	Check  func(ctx contractapi.TransactionContextInterface, trade *Trade) error
+	Record func(ctx contractapi.TransactionContextInterface, trade *Trade) error
+}
+
+// TradePolicy returns the rules AcceptOffer and CheckOffer evaluate in order.
+type TradePolicy interface {
+	Rules(cfg ContractConfig) []TradeRule
+}
+
+// TradeRules is a TradePolicy with a fixed list of rules.
+type TradeRules []TradeRule
+
+func (rules TradeRules) Rules(ContractConfig) []TradeRule {
+	return rules
+}
+
+// ConfigTradePolicy applies BaseTradeRules and the rules enabled by the Trade section of the configuration.
+type ConfigTradePolicy struct {
+}
+
+func (p *ConfigTradePolicy) Rules(cfg ContractConfig) []TradeRule {
+	rules := BaseTradeRules()
+	if cfg.Trade.SameSite {
+		rules = append(rules, SameSiteRule())
+	}
+	if cfg.Trade.SameType {
+		rules = append(rules, SameTypeRule())
+	}
+	if cfg.Trade.MinNoticeHours > 0 {
+		rules = append(rules, MinNoticeRule(time.Duration(cfg.Trade.MinNoticeHours)*time.Hour))
+	}
+	if cfg.Trade.MaxTradesPerMonth > 0 {
+		rules = append(rules, MaxTradesPerMonthRule(cfg.Trade.MaxTradesPerMonth))
+	}
+	return rules
+}
+
+// tradePolicy returns the injected TradePolicy, ConfigTradePolicy if there is none.
+func (c *VaccinationContract) tradePolicy() TradePolicy {
+	if c.TradePolicy == nil {
+		return &ConfigTradePolicy{}
+	}
+	return c.TradePolicy
+}
+
+// BaseTradeRules are the ownership, status, expiry, dose interval, compatibility and same-day rules every trade has to pass.
+//
+// The patients keep their vaccine type and previous dose and exchange the dates,
+// so the compatibility of each patient is checked with the date they receive.
+func BaseTradeRules() []TradeRule {
+	return []TradeRule{
+		{Name: "recipient", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if trade.Recipient != trade.Offer.Recipient {
+				return contracterr.New(contracterr.Unauthorized, "%s is not the recipient of the offer: %s", trade.Recipient, trade.Offer.Uuid)
+			}
+			return nil
+		}},
+		{Name: "sender-owner", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if trade.SenderSlot.Owner != trade.Offer.Sender {
+				return contracterr.New(contracterr.Conflict, "sender: %s doesn't own the slot", trade.Offer.Sender)
+			}
+			return nil
+		}},
+		{Name: "recipient-owner", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if trade.RecipientSlot.Owner != trade.Recipient {
+				return contracterr.New(contracterr.Conflict, "recipient: %s doesn't own the slot", trade.Recipient)
+			}
+			return nil
+		}},
+		{Name: "sender-status", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if !trade.SenderSlot.IsLive() {
+				return contracterr.New(contracterr.RuleViolation, "sender slot is %s", trade.SenderSlot.Status)
+			}
+			return nil
+		}},
+		{Name: "recipient-status", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if !trade.RecipientSlot.IsLive() {
+				return contracterr.New(contracterr.RuleViolation, "recipient slot is %s", trade.RecipientSlot.Status)
+			}
+			return nil
+		}},
+		{Name: "sender-expiry", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if time.Time(trade.SenderSlot.Date).Before(trade.Now) {
+				return contracterr.New(contracterr.Expired, "sender slot has expired")
+			}
+			return nil
+		}},
+		{Name: "recipient-expiry", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			if time.Time(trade.RecipientSlot.Date).Before(trade.Now) {
+				return contracterr.New(contracterr.Expired, "recipient slot has expired")
+			}
+			return nil
+		}},
+		{Name: "recipient-interval", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			return checkInterval(ctx, trade.Config, trade.SenderSlot.Previous, trade.RecipientSlot.Date)
+		}},
+		{Name: "sender-interval", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			return checkInterval(ctx, trade.Config, trade.RecipientSlot.Previous, trade.SenderSlot.Date)
+		}},
+		{Name: "sender-compatibility", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			return checkCompatibility(ctx, trade.Config, trade.SenderSlot.Previous, trade.SenderSlot.Type)
+		}},
+		{Name: "recipient-compatibility", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			return checkCompatibility(ctx, trade.Config, trade.RecipientSlot.Previous, trade.RecipientSlot.Type)
+		}},
+		{Name: "sender-date", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			return checkSwapDate(ctx, trade.Offer.Sender, trade.SenderSlot, trade.RecipientSlot)
+		}},
+		{Name: "recipient-date", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			return checkSwapDate(ctx, trade.Recipient, trade.RecipientSlot, trade.SenderSlot)
+		}},
+	}
+}
+
+// checkSwapDate enforces the same-day uniqueness of IssueSlot for owner giving away given and receiving received.
+func checkSwapDate(ctx contractapi.TransactionContextInterface, owner string, given, received *VaccinationSlot) error {
+	if given.Date.String() == received.Date.String() {
+		return nil
+	}
+	occupied, err := dateOccupied(ctx, owner, received.Date)
+	if err != nil {
+		return err
+	}
+	if occupied {
+		return contracterr.New(contracterr.Conflict, "%s already has a slot on %s", owner, received.Date)
+	}
+	return nil
+}
+
+// SameSiteRule only allows trades between slots of the same site.
+func SameSiteRule() TradeRule {
+	return TradeRule{Name: "same-site", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+		if trade.SenderSlot.Site != trade.RecipientSlot.Site {
+			return contracterr.New(contracterr.RuleViolation, "slots are at different sites")
+		}
+		return nil
+	}}
+}
+
+// SameTypeRule only allows trades between slots of the same vaccine type.
+func SameTypeRule() TradeRule {
+	return TradeRule{Name: "same-type", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+		if trade.SenderSlot.Type != trade.RecipientSlot.Type {
+			return contracterr.New(contracterr.RuleViolation, "slots are of different vaccine types")
+		}
+		return nil
+	}}
+}
+
+// MinNoticeRule forbids trading slots whose date is closer than notice.
+func MinNoticeRule(notice time.Duration) TradeRule {
+	return TradeRule{Name: "min-notice", Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+		limit := trade.Now.Add(notice)
+		for _, slot := range []*VaccinationSlot{trade.SenderSlot, trade.RecipientSlot} {
+			if time.Time(slot.Date).Before(limit) {
+				return contracterr.New(contracterr.RuleViolation, "slot %s is less than %v away", slot.TokenId, notice)
+			}
+		}
+		return nil
+	}}
+}
+
+// MaxTradesPerMonthRule limits the trades of both parties in the calendar month of the trade.
+// The trades are counted in trade count records.
+func MaxTradesPerMonthRule(max int) TradeRule {
+	return TradeRule{
+		Name: "max-trades-per-month",
+		Check: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			for _, owner := range []string{trade.Offer.Sender, trade.Recipient} {
+				count, err := getTradeCount(ctx, owner, trade.Now)
+				if err != nil {
+					return err
+				}
+				if count.Count >= max {
+					return contracterr.New(contracterr.RuleViolation, "%s has already traded %d times in %s", owner, count.Count, count.Month)
+				}
+			}
+			return nil
+		},
+		Record: func(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+			for _, owner := range []string{trade.Offer.Sender, trade.Recipient} {
+				count, err := getTradeCount(ctx, owner, trade.Now)
+				if err != nil {
+					return err
+				}
+				count.Count++
+				err = count.put(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// TradeCount is the number of trades accepted by Owner in Month, stored as trades.owner.month.
+type TradeCount struct {
+	Owner   string `json:"owner"`
+	Month   string `json:"month"`
+	Count   int    `json:"count"`
+	Version int    `json:"schemaVersion"`
+}
+
+func tradeCountKey(ctx contractapi.TransactionContextInterface, owner, month string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(tradeCountPrefix, []string{owner, month})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+// getTradeCount returns the trade count of owner in the month of t, zero if there is none.
+func getTradeCount(ctx contractapi.TransactionContextInterface, owner string, t time.Time) (*TradeCount, error) {
+	count := &TradeCount{Owner: owner, Month: t.UTC().Format("2006-01")}
+	key, err := tradeCountKey(ctx, owner, count.Month)
+	if err != nil {
+		return nil, err
+	}
+	countBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state %s: %w", key, err)
+	}
+	if len(countBytes) == 0 {
+		return count, nil
+	}
+	err = decodeRecord(tradeCountPrefix, countBytes, count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return count, nil
+}
+
+func (count *TradeCount) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := tradeCountKey(ctx, count.Owner, count.Month)
+	if err != nil {
+		return err
+	}
+	count.Version = SchemaVersion
+	countBytes, err := json.Marshal(count)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trade count: %w", err)
+	}
+	err = ctx.GetStub().PutState(key, countBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const (
+	// patientCollection is the private data collection of the medical station
+	// holding the real patient identities behind the pseudonyms in public state.
+	patientCollection = "patientCollection"
+	patientPrefix     = "patient"
+)
+
+// Keys of the transient map read by IssueSlotTransient.
+const (
+	transientVaccine  = "vaccine"
+	transientDate     = "date"
+	transientPatient  = "patient"
+	transientPrevious = "previous"
+	transientSite     = "site"
+)
+
+// PatientRecord links a pseudonym used as slot owner in public state
+// to the real identity of the patient. It is only stored in patientCollection.
+type PatientRecord struct {
+	Pseudonym string `json:"pseudonym"`
+	Patient   string `json:"patient"`
+}
+
+// IssueSlotTransient works like IssueSlot, but reads its parameters from the
+// transient map, so the patient identity never appears in the transaction arguments.
+//
+// Required transient keys: vaccine, date, patient. Optional: previous, site.
+//
+// The slot is owned by the pseudonym of the patient,
+// the identity itself is only written to the medical station's private collection.
+//
+// A client request id can be passed in the requestId transient key, see IssueSlot.
+func (c *VaccinationContract) IssueSlotTransient(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "IssueSlotTransient")
+	if err != nil {
+		return "", err
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transient map: %w", err)
+	}
+
+	params := make(map[string]string)
+	for _, k := range []string{transientVaccine, transientDate, transientPatient} {
+		v, ok := transient[k]
+		if !ok || len(v) == 0 {
+			return "", contracterr.New(contracterr.InvalidArgument, "%s must be present in the transient map", k)
+		}
+		params[k] = string(v)
+	}
+	previous := string(transient[transientPrevious])
+	site := string(transient[transientSite])
+
+	owner, err := pseudonym(ctx, params[transientPatient])
+	if err != nil {
+		return "", err
+	}
+
+	request, replayed, err := replayRequest(ctx, "IssueSlotTransient", params[transientVaccine], params[transientDate], site, owner, previous)
+	if err != nil {
+		return "", err
+	}
+	if replayed {
+		return request.Result, nil
+	}
+
+	tokenId, err := c.issueSlot(ctx, params[transientVaccine], params[transientDate], site, owner, previous)
+	if err != nil {
+		return "", err
+	}
+
+	record := &PatientRecord{
+		Pseudonym: owner,
+		Patient:   params[transientPatient],
+	}
+	err = record.put(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	err = saveRequest(ctx, request, tokenId)
+	if err != nil {
+		return "", err
+	}
+	return tokenId, nil
+}
+
+func (record *PatientRecord) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := ctx.GetStub().CreateCompositeKey(patientPrefix, []string{record.Pseudonym})
+	if err != nil {
+		return fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal patient record: %w", err)
+	}
+
+	err = ctx.GetStub().PutPrivateData(patientCollection, key, recordBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutPrivateData %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const verificationPrefix = "verification"
+
+// verificationTimeFormat has a fixed width, so verification keys sort by check time.
+const verificationTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Verification is the audit record of a check of IsVaccinated, stored as verification.patient.checkedAt.txId,
+// so the checks of a patient are iterated in chronological order.
+// It doesn't hold the result, the public state must not disclose whether the patient is vaccinated.
+type Verification struct {
+	Patient   string          `json:"patient"`
+	Verifier  string          `json:"verifier"`
+	Type      VaccinationType `json:"type"`
+	AsOf      VaccinationDate `json:"asOf"`
+	CheckedAt time.Time       `json:"checkedAt"`
+	Version   int             `json:"schemaVersion"`
+}
+
+func (verification *Verification) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := ctx.GetStub().CreateCompositeKey(verificationPrefix, []string{
+		verification.Patient,
+		verification.CheckedAt.UTC().Format(verificationTimeFormat),
+		ctx.GetStub().GetTxID(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+
+	verification.Version = SchemaVersion
+	verificationBytes, err := json.Marshal(verification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal verification: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(key, verificationBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+// IsVaccinated checks whether the patient pseudonym completed a series of vaccine by asOf,
+// without disclosing anything else of the patient.
+//
+// A series is completed when every planned dose is administered on or before asOf.
+// Administered doses issued before series existed count as completed.
+// Only the patient, the medical station and verifiers with a vaccinated consent of the patient can check it, see GrantConsent.
+//
+// Every submitted check is logged without its result, see GetVerifications.
+//
+// AsOf format must be 2006-01-02.
+func (c *VaccinationContract) IsVaccinated(ctx contractapi.TransactionContextInterface, patient, vaccine, asOf string) (_ bool, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "IsVaccinated")
+	if err != nil {
+		return false, err
+	}
+
+	vt, err := parseVaccine(vaccine)
+	if err != nil {
+		return false, err
+	}
+	date, err := parseDate(asOf)
+	if err != nil {
+		return false, err
+	}
+	err = authorizeRead(ctx, patient, ConsentVaccinated)
+	if err != nil {
+		return false, err
+	}
+
+	vaccinated, err := isVaccinated(ctx, patient, vt, date)
+	if err != nil {
+		return false, err
+	}
+
+	verifier, err := getSender(ctx)
+	if err != nil {
+		return false, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	verification := &Verification{
+		Patient:   patient,
+		Verifier:  verifier,
+		Type:      vt,
+		AsOf:      date,
+		CheckedAt: now,
+	}
+	err = verification.put(ctx)
+	if err != nil {
+		return false, err
+	}
+	return vaccinated, nil
+}
+
+func isVaccinated(ctx contractapi.TransactionContextInterface, patient string, vt VaccinationType, asOf VaccinationDate) (bool, error) {
+	slots, err := getSlots(ctx, patient)
+	if err != nil {
+		return false, err
+	}
+
+	administered := make(map[string]int)
+	for _, slot := range slots {
+		if slot.Status != StatusAdministered || time.Time(slot.Date).After(time.Time(asOf)) {
+			continue
+		}
+		if len(slot.SeriesId) == 0 {
+			if slot.Type == vt {
+				return true, nil
+			}
+			continue
+		}
+		administered[slot.SeriesId]++
+	}
+
+	for seriesId, doses := range administered {
+		series, err := getSeries(ctx, patient, seriesId)
+		if err != nil {
+			return false, err
+		}
+		if series.Product == vt && doses >= series.PlannedDoses {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetVerifications returns the IsVaccinated checks of the patient pseudonym in chronological order.
+// Only the patient and the medical station can read them.
+func (c *VaccinationContract) GetVerifications(ctx contractapi.TransactionContextInterface, patient string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetVerifications")
+	if err != nil {
+		return "", err
+	}
+	ok, err := isPatientOrStation(ctx, patient)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", contracterr.New(contracterr.Unauthorized, "only %s and the medical station can read the verifications", patient)
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(verificationPrefix, []string{patient})
+	if err != nil {
+		return "", fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", verificationPrefix, err)
+	}
+	defer iterator.Close()
+
+	verifications := make([]*Verification, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return "", fmt.Errorf("failure while iterating: %w", err)
+		}
+		verification := &Verification{}
+		err = decodeRecord(verificationPrefix, kv.Value, verification)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode %s: %w", kv.Key, err)
+		}
+		verifications = append(verifications, verification)
+	}
+
+	verificationsBytes, err := json.Marshal(verifications)
+	if err != nil {
+		return "", err
+	}
+	return string(verificationsBytes), nil
+}
@@ -0,0 +1,351 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+const waitlistPrefix = "waitlist"
+
+// waitlistTimeFormat has a fixed width, so waitlist keys sort by join time.
+const waitlistTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// WaitlistEntry is a patient waiting for a cancelled slot of a vaccine type at a site.
+//
+// Entries are stored as waitlist.type.site.joinedAt.patient,
+// so the entries of a type and site are iterated in join order.
+type WaitlistEntry struct {
+	Patient string          `json:"patient"`
+	Type    VaccinationType `json:"type"`
+	Site    string          `json:"site"`
+
+	// Acceptable slot dates, both inclusive.
+	From VaccinationDate `json:"from"`
+	To   VaccinationDate `json:"to"`
+
+	// Previous dose of the patient, the assigned slot has to be within its dose interval.
+	Previous string `json:"previous,omitempty"`
+
+	JoinedAt time.Time `json:"joinedAt"`
+	Version  int       `json:"schemaVersion"`
+}
+
+// accepts reports whether date is within the acceptable dates of the entry.
+func (entry *WaitlistEntry) accepts(date VaccinationDate) bool {
+	return !time.Time(date).Before(time.Time(entry.From)) && !time.Time(date).After(time.Time(entry.To))
+}
+
+func (entry *WaitlistEntry) key(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(waitlistPrefix, []string{
+		string(entry.Type),
+		entry.Site,
+		entry.JoinedAt.UTC().Format(waitlistTimeFormat),
+		entry.Patient,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create CompositeKey: %w", err)
+	}
+	return key, nil
+}
+
+func (entry *WaitlistEntry) put(ctx contractapi.TransactionContextInterface) error {
+	key, err := entry.key(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry.Version = SchemaVersion
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal waitlist entry: %w", err)
+	}
+
+	err = ctx.GetStub().PutState(key, entryBytes)
+	if err != nil {
+		return fmt.Errorf("failed to PutState %s: %w", key, err)
+	}
+	return nil
+}
+
+func (entry *WaitlistEntry) del(ctx contractapi.TransactionContextInterface) error {
+	key, err := entry.key(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to DelState %s: %w", key, err)
+	}
+	return nil
+}
+
+// getWaitlist returns the entries of vaccine and site in join order.
+func getWaitlist(ctx contractapi.TransactionContextInterface, vaccine VaccinationType, site string) ([]*WaitlistEntry, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(waitlistPrefix, []string{string(vaccine), site})
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetStateByPartialCompositeKey %s: %w", waitlistPrefix, err)
+	}
+	defer iterator.Close()
+
+	entries := make([]*WaitlistEntry, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failure while iterating: %w", err)
+		}
+		entry := &WaitlistEntry{}
+		err = decodeRecord(waitlistPrefix, kv.Value, entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", kv.Key, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// findWaitlistEntry returns the entry of patient or nil if the patient isn't waiting.
+func findWaitlistEntry(ctx contractapi.TransactionContextInterface, vaccine VaccinationType, site, patient string) (*WaitlistEntry, error) {
+	entries, err := getWaitlist(ctx, vaccine, site)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Patient == patient {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// JoinWaitlist puts the sender on the waitlist of vaccine at site.
+//
+// From and to are the acceptable slot dates in 2006-01-02 format, both inclusive.
+// Previous is the optional previous dose of the sender, see VaccinationSlotData, it must be administered.
+func (c *VaccinationContract) JoinWaitlist(ctx contractapi.TransactionContextInterface, vaccine, site, from, to, previous string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "JoinWaitlist")
+	if err != nil {
+		return err
+	}
+
+	sender, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+
+	vt, err := parseVaccine(vaccine)
+	if err != nil {
+		return err
+	}
+
+	entry := &WaitlistEntry{
+		Patient:  sender,
+		Type:     vt,
+		Site:     site,
+		Previous: previous,
+	}
+	entry.From, err = parseDate(from)
+	if err != nil {
+		return err
+	}
+	entry.To, err = parseDate(to)
+	if err != nil {
+		return err
+	}
+	if time.Time(entry.To).Before(time.Time(entry.From)) {
+		return contracterr.New(contracterr.InvalidArgument, "from must not be after to")
+	}
+
+	entry.JoinedAt, err = txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if time.Time(entry.To).Before(entry.JoinedAt) {
+		return contracterr.New(contracterr.Expired, "acceptable dates are in the past")
+	}
+
+	if len(previous) > 0 {
+		prev, err := readVaccinationSlot(ctx, previous)
+		if err != nil {
+			return err
+		}
+		if prev.Owner != sender {
+			return contracterr.New(contracterr.Unauthorized, "%s doesn't own %s", sender, previous)
+		}
+		if prev.Status != StatusAdministered {
+			return contracterr.New(contracterr.RuleViolation, "previous dose %s is %s, not administered", previous, prev.Status)
+		}
+	}
+
+	existing, err := findWaitlistEntry(ctx, vt, site, sender)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return contracterr.New(contracterr.Conflict, "%s is already on the waitlist", sender)
+	}
+
+	return entry.put(ctx)
+}
+
+// LeaveWaitlist removes the sender from the waitlist of vaccine at site.
+func (c *VaccinationContract) LeaveWaitlist(ctx contractapi.TransactionContextInterface, vaccine, site string) (err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "LeaveWaitlist")
+	if err != nil {
+		return err
+	}
+
+	vt, err := parseVaccine(vaccine)
+	if err != nil {
+		return err
+	}
+	sender, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry, err := findWaitlistEntry(ctx, vt, site, sender)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return contracterr.New(contracterr.NotFound, "%s is not on the waitlist", sender)
+	}
+	return entry.del(ctx)
+}
+
+// GetWaitlist returns the waitlist of vaccine at site in join order.
+func (c *VaccinationContract) GetWaitlist(ctx contractapi.TransactionContextInterface, vaccine, site string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "GetWaitlist")
+	if err != nil {
+		return "", err
+	}
+
+	vt, err := parseVaccine(vaccine)
+	if err != nil {
+		return "", err
+	}
+	entries, err := getWaitlist(ctx, vt, site)
+	if err != nil {
+		return "", err
+	}
+
+	entriesBytes, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	return string(entriesBytes), nil
+}
+
+// AssignFromWaitlist gives a cancelled slot of the pool to the first eligible patient
+// on the waitlist of its vaccine type and site, and returns the pseudonym of the patient.
+//
+// A patient is eligible if the slot date is acceptable for them,
+// is within the dose interval of their previous dose and they have no other slot on that date.
+// The entry of the patient is removed from the waitlist.
+func (c *VaccinationContract) AssignFromWaitlist(ctx contractapi.TransactionContextInterface, slotUuid string) (_ string, err error) {
+	defer contracterr.Normalize(&err)
+
+	err = c.authorize(ctx, "AssignFromWaitlist")
+	if err != nil {
+		return "", err
+	}
+
+	slot, err := readVaccinationSlot(ctx, slotUuid)
+	if err != nil {
+		return "", err
+	}
+	if slot.Status != StatusCancelled {
+		return "", contracterr.New(contracterr.RuleViolation, "slot %s is not in the pool", slotUuid)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	if time.Time(slot.Date).Before(now) {
+		return "", contracterr.New(contracterr.Expired, "slot %s has expired", slotUuid)
+	}
+
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	patient, err := c.assignFromWaitlist(ctx, cfg, slot, "")
+	if err != nil {
+		return "", err
+	}
+	if len(patient) == 0 {
+		return "", contracterr.New(contracterr.NotFound, "no eligible patient on the waitlist of %s", slotUuid)
+	}
+	return patient, nil
+}
+
+// assignFromWaitlist gives the cancelled slot of the pool to the first eligible patient on its waitlist other than skip,
+// and returns the pseudonym of the patient, or an empty string if no patient is eligible.
+func (c *VaccinationContract) assignFromWaitlist(ctx contractapi.TransactionContextInterface, cfg ContractConfig, slot *VaccinationSlot, skip string) (string, error) {
+	entries, err := getWaitlist(ctx, slot.Type, slot.Site)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		if entry.Patient == skip {
+			continue
+		}
+		eligible, err := c.waitlistEligible(ctx, cfg, entry, slot)
+		if err != nil {
+			return "", err
+		}
+		if !eligible {
+			continue
+		}
+
+		err = entry.del(ctx)
+		if err != nil {
+			return "", err
+		}
+		err = c.reassignSlot(ctx, slot, entry.Patient, entry.Previous)
+		if err != nil {
+			return "", err
+		}
+		return entry.Patient, nil
+	}
+	return "", nil
+}
+
+func (c *VaccinationContract) waitlistEligible(ctx contractapi.TransactionContextInterface, cfg ContractConfig, entry *WaitlistEntry, slot *VaccinationSlot) (bool, error) {
+	if !entry.accepts(slot.Date) {
+		return false, nil
+	}
+
+	err := checkInterval(ctx, cfg, entry.Previous, slot.Date)
+	if err == nil {
+		err = checkCompatibility(ctx, cfg, entry.Previous, slot.Type)
+	}
+	if err == nil {
+		_, _, err = nextDose(ctx, entry.Patient, entry.Previous, slot.TokenId, newPendingMoves())
+	}
+	if code := contracterr.CodeOf(err); code == contracterr.RuleViolation || code == contracterr.Conflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	occupied, err := dateOccupied(ctx, entry.Patient, slot.Date)
+	if err != nil {
+		return false, err
+	}
+	return !occupied, nil
+}
@@ -0,0 +1,103 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SchemaVersion is the version of the entities written by this chaincode.
+// Records stored before versioning have no version and are treated as schemaVersionLegacy.
+const (
+	schemaVersionLegacy = 1
+	SchemaVersion       = 4
+)
+
+const schemaVersionField = "schemaVersion"
+
+// upgrade converts a raw record of version v to version v+1 in place.
+type upgrade func(record map[string]json.RawMessage) error
+
+// upgrades holds the upgrade steps of every stored entity by key prefix, indexed by the source version.
+var upgrades = map[string]map[int]upgrade{
+	configPrefix:       {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	vsPrefix:           {1: noUpgrade, 2: burnedToStatus, 3: noUpgrade},
+	offerPrefix:        {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	approvalPrefix:     {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	waitlistPrefix:     {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	requestPrefix:      {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	tradeCountPrefix:   {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	seriesPrefix:       {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	consentPrefix:      {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+	verificationPrefix: {1: noUpgrade, 2: noUpgrade, 3: noUpgrade},
+}
+
+// migrationHooks store the derived state of a record rewritten by MigrateState.
+// Slots of schema version 3 and older aren't in the site and date index.
+var migrationHooks = map[string]func(ctx contractapi.TransactionContextInterface, record []byte) error{
+	vsPrefix: indexSlotSite,
+}
+
+// noUpgrade is used when a version only differs in the version field.
+func noUpgrade(map[string]json.RawMessage) error {
+	return nil
+}
+
+// recordVersion returns the schema version of a stored record.
+func recordVersion(b []byte) (int, error) {
+	versioned := struct {
+		Version int `json:"schemaVersion"`
+	}{}
+	err := json.Unmarshal(b, &versioned)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal schema version: %w", err)
+	}
+	if versioned.Version == 0 {
+		return schemaVersionLegacy, nil
+	}
+	return versioned.Version, nil
+}
+
+// upgradeRecord applies the upgrade steps of prefix to b until it reaches SchemaVersion.
+func upgradeRecord(prefix string, b []byte) ([]byte, error) {
+	version, err := recordVersion(b)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("unsupported schema version %d of %s record", version, prefix)
+	}
+	if version == SchemaVersion {
+		return b, nil
+	}
+
+	record := make(map[string]json.RawMessage)
+	err = json.Unmarshal(b, &record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s record: %w", prefix, err)
+	}
+
+	for ; version < SchemaVersion; version++ {
+		step, ok := upgrades[prefix][version]
+		if !ok {
+			return nil, fmt.Errorf("no upgrade of %s record from schema version %d", prefix, version)
+		}
+		err = step(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade %s record from schema version %d: %w", prefix, version, err)
+		}
+	}
+
+	record[schemaVersionField] = json.RawMessage(fmt.Sprint(SchemaVersion))
+	return json.Marshal(record)
+}
+
+// decodeRecord upgrades b to SchemaVersion and unmarshals it into v.
+func decodeRecord(prefix string, b []byte, v interface{}) error {
+	upgraded, err := upgradeRecord(prefix, b)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, v)
+}
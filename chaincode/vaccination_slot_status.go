@@ -0,0 +1,137 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
+)
+
+// SlotStatus is the lifecycle state of a slot.
+type SlotStatus string
+
+const (
+	// StatusIssued slots are live, they can be traded and administered.
+	StatusIssued       SlotStatus = "issued"
+	StatusAdministered SlotStatus = "administered"
+	StatusCancelled    SlotStatus = "cancelled"
+	StatusRevoked      SlotStatus = "revoked"
+	StatusExpired      SlotStatus = "expired"
+	StatusNoShow       SlotStatus = "no-show"
+)
+
+// transitions lists the statuses reachable from each status.
+// Statuses missing from the table are final.
+var transitions = map[SlotStatus][]SlotStatus{
+	StatusIssued: {StatusAdministered, StatusCancelled, StatusRevoked, StatusExpired, StatusNoShow},
+	// Cancelled slots are reissued when the medical station reassigns them,
+	// or expire in the pool.
+	StatusCancelled: {StatusIssued, StatusExpired},
+}
+
+// StatusChange records a transition of a slot.
+type StatusChange struct {
+	From      SlotStatus `json:"from"`
+	To        SlotStatus `json:"to"`
+	Actor     string     `json:"actor"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// IsValid reports whether status is one of the known statuses.
+func (status SlotStatus) IsValid() bool {
+	switch status {
+	case StatusIssued, StatusAdministered, StatusCancelled, StatusRevoked, StatusExpired, StatusNoShow:
+		return true
+	}
+	return false
+}
+
+func (status SlotStatus) canTransitionTo(to SlotStatus) bool {
+	for _, s := range transitions[status] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isIndexed reports whether slots in status are kept in the balance index of their owner.
+// Revoked, expired and no-show slots are removed from it.
+func (status SlotStatus) isIndexed() bool {
+	switch status {
+	case StatusIssued, StatusAdministered, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// IsLive reports whether the slot can still be traded and administered.
+func (slot *VaccinationSlot) IsLive() bool {
+	return slot.Status == StatusIssued
+}
+
+// occupies reports whether the slot blocks its date for its owner.
+func (slot *VaccinationSlot) occupies() bool {
+	return slot.Status == StatusIssued || slot.Status == StatusAdministered
+}
+
+// txTime returns the timestamp of the transaction.
+// It has to be used instead of the wall clock, so every endorser gets the same result.
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get tx timestamp: %w", err)
+	}
+	return ts.AsTime(), nil
+}
+
+// transition moves the slot to status to and records the invoking client as actor.
+// The slot is not stored.
+func (slot *VaccinationSlot) transition(ctx contractapi.TransactionContextInterface, to SlotStatus) error {
+	if !slot.Status.canTransitionTo(to) {
+		return contracterr.New(contracterr.RuleViolation, "slot %s can't transition from %s to %s", slot.TokenId, slot.Status, to)
+	}
+
+	actor, err := getSender(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	slot.StatusHistory = append(slot.StatusHistory, StatusChange{
+		From:      slot.Status,
+		To:        to,
+		Actor:     actor,
+		Timestamp: now,
+	})
+	slot.Status = to
+	return nil
+}
+
+// burnedToStatus upgrades a slot record of schema version 2 by replacing burned with status.
+func burnedToStatus(record map[string]json.RawMessage) error {
+	burned := false
+	if b, ok := record["burned"]; ok {
+		err := json.Unmarshal(b, &burned)
+		if err != nil {
+			return err
+		}
+		delete(record, "burned")
+	}
+
+	status := StatusIssued
+	if burned {
+		status = StatusAdministered
+	}
+	statusBytes, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	record["status"] = statusBytes
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"time"
+
+	"github.com/perryd01/vaccination-slot/chaincode/contracterr"
 )
 
 type VaccinationType string
@@ -33,8 +35,82 @@ func (vt *VaccinationType) MarshalJSON() ([]byte, error) {
 	return []byte("\"" + string(*vt) + "\""), nil
 }
 
+// IsValid reports whether vt is one of the known vaccine types.
+func (vt VaccinationType) IsValid() bool {
+	_, ok := deadlines[vt]
+	return ok
+}
+
+// parseVaccine parses a vaccine type argument.
+func parseVaccine(s string) (VaccinationType, error) {
+	vt := VaccinationType(s)
+	if !vt.IsValid() {
+		return vt, contracterr.New(contracterr.InvalidArgument, "unknown vaccine type: %s", s)
+	}
+	return vt, nil
+}
+
 var deadlines = map[VaccinationType]time.Duration{}
 
+// defaultMinIntervalDays is the minimum days between two doses without configuration.
+const defaultMinIntervalDays = 14
+
+// VaccineConfig overrides the dose interval of a vaccine type, 0 keeps the value of the contract configuration.
+type VaccineConfig struct {
+	// Minimum days between a dose of the type and the next dose.
+	MinIntervalDays int `json:"minIntervalDays,omitempty"`
+	// Maximum days between a dose of the type and the next dose.
+	MaxIntervalDays int `json:"maxIntervalDays,omitempty"`
+	// Planned doses of a series started with the type.
+	Doses int `json:"doses,omitempty"`
+	// BurnToken issues the next dose of the series automatically.
+	AutoNextDose bool `json:"autoNextDose,omitempty"`
+}
+
+// defaultDoses is the number of planned doses of a series without configuration.
+const defaultDoses = 2
+
+// validateVaccines checks the vaccine type settings of the configuration.
+func (cfg ContractConfig) validateVaccines() error {
+	for vt, vaccine := range cfg.Vaccines {
+		if !vt.IsValid() {
+			return contracterr.New(contracterr.InvalidArgument, "unknown vaccine type: %s", vt)
+		}
+		if vaccine.MinIntervalDays < 0 || vaccine.MaxIntervalDays < 0 {
+			return contracterr.New(contracterr.InvalidArgument, "intervals of %s must not be negative", vt)
+		}
+		if vaccine.Doses < 0 {
+			return contracterr.New(contracterr.InvalidArgument, "doses of %s must not be negative", vt)
+		}
+		min, max := cfg.doseInterval(vt)
+		if min > max {
+			return contracterr.New(contracterr.InvalidArgument, "minIntervalDays of %s must not be after maxIntervalDays", vt)
+		}
+	}
+	return nil
+}
+
+// doseInterval returns the [min, max] time between a dose of vt and the next dose.
+func (cfg ContractConfig) doseInterval(vt VaccinationType) (time.Duration, time.Duration) {
+	min, max := time.Duration(cfg.MinIntervalDays)*24*time.Hour, deadlines[vt]
+	vaccine := cfg.Vaccines[vt]
+	if vaccine.MinIntervalDays > 0 {
+		min = time.Duration(vaccine.MinIntervalDays) * 24 * time.Hour
+	}
+	if vaccine.MaxIntervalDays > 0 {
+		max = time.Duration(vaccine.MaxIntervalDays) * 24 * time.Hour
+	}
+	return min, max
+}
+
+// plannedDoses returns the number of doses of a series started with vt.
+func (cfg ContractConfig) plannedDoses(vt VaccinationType) int {
+	if doses := cfg.Vaccines[vt].Doses; doses > 0 {
+		return doses
+	}
+	return defaultDoses
+}
+
 func init() {
 	dAlpha, err := time.ParseDuration("720h")
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 func main() {
 	contract := &cc.VaccinationContract{
 		IdGenerator: &cc.TokenIdGenerator{},
+		TradePolicy: &cc.ConfigTradePolicy{},
 	}
 	contract.Info.Version = "1.1.0"
 	contract.Info.Description = "VaccinationSlots chaincode"